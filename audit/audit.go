@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/NetCarrier/ncservice"
+)
+
+// Actions of a record
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is a single field that changed, named by its JSON name
+type Change struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Record is who changed what and when
+type Record struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Tenant string    `json:"tenant,omitempty"`
+	Entity string    `json:"entity"`
+	// Key is the primary key of the entity from ncservice.KeyString
+	Key     string   `json:"key"`
+	Action  string   `json:"action"`
+	Changes []Change `json:"changes"`
+}
+
+// Query selects the history of a single entity. Tenant, Since, Until and Limit
+// are optional. Since is inclusive and Until is exclusive.
+type Query struct {
+	Entity string
+	Key    string
+	Tenant string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (q Query) matches(r Record) bool {
+	return r.Entity == q.Entity && r.Key == q.Key &&
+		(q.Tenant == "" || r.Tenant == q.Tenant) &&
+		(q.Since.IsZero() || !r.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || r.Time.Before(q.Until))
+}
+
+// Sink stores records and reads them back in the order they happened
+type Sink interface {
+	Write(ctx context.Context, r Record) error
+	History(ctx context.Context, q Query) ([]Record, error)
+}
+
+// Who is the context of a write
+type Who struct {
+	Actor  string
+	Tenant string
+}
+
+// Auditor records the changes of writes to a sink
+type Auditor struct {
+	Sink Sink
+	// Now is optional and defaults to time.Now
+	Now func() time.Time
+}
+
+// Record writes the difference between the before and after versions of an
+// entity. before is nil for a create and after is nil for a delete, otherwise
+// both are the same struct type. Password fields are hidden with
+// ncservice.HidePasswords. Nothing is written if nothing changed and the record
+// is returned otherwise.
+func (a *Auditor) Record(ctx context.Context, who Who, entity string, before any, after any) (*Record, error) {
+	r := &Record{
+		Actor:  who.Actor,
+		Tenant: who.Tenant,
+		Entity: entity,
+		Action: ActionUpdate,
+	}
+	keyed := after
+	switch {
+	case isNil(before) && isNil(after):
+		return nil, errors.New("audit needs a before or an after")
+	case isNil(before):
+		r.Action = ActionCreate
+	case isNil(after):
+		r.Action = ActionDelete
+		keyed = before
+	}
+	var err error
+	if r.Key, err = ncservice.KeyString(keyed); err != nil {
+		return nil, err
+	}
+	beforeVals, err := readValues(before)
+	if err != nil {
+		return nil, err
+	}
+	afterVals, err := readValues(after)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range ncservice.DiffVals(beforeVals, afterVals) {
+		r.Changes = append(r.Changes, Change{Field: d.Col, From: d.Orig, To: d.Updated})
+	}
+	if len(r.Changes) == 0 && r.Action == ActionUpdate {
+		return nil, nil
+	}
+	if a.Now != nil {
+		r.Time = a.Now()
+	} else {
+		r.Time = time.Now()
+	}
+	if err := a.Sink.Write(ctx, *r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// History of an entity, oldest first
+func (a *Auditor) History(ctx context.Context, q Query) ([]Record, error) {
+	return a.Sink.History(ctx, q)
+}
+
+func readValues(h any) ([]ncservice.Value, error) {
+	if isNil(h) {
+		return nil, nil
+	}
+	return ncservice.ReadValues(h, ncservice.ReadValueOptions{
+		ColumnMapper: ncservice.ApiColumns,
+		Prefixer:     ncservice.ApiPrefix,
+		Reader:       ncservice.HidePasswords,
+	})
+}
+
+func isNil(h any) bool {
+	if h == nil {
+		return true
+	}
+	ref := reflect.ValueOf(h)
+	return ref.Kind() == reflect.Ptr && ref.IsNil()
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditTestUser struct {
+	ID       int    `json:"id" gorm:"column:id;primaryKey"`
+	Name     string `json:"name" gorm:"column:name"`
+	Password string `json:"password" gorm:"column:pass" password:"true"`
+}
+
+func TestAuditor(t *testing.T) {
+	ctx := context.Background()
+	sink := NewFileSink(filepath.Join(t.TempDir(), "audit.ndjson"))
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := &Auditor{Sink: sink, Now: func() time.Time { now = now.Add(time.Minute); return now }}
+	who := Who{Actor: "admin", Tenant: "acme"}
+
+	v1 := &auditTestUser{ID: 7, Name: "joe", Password: "secret"}
+	r, err := a.Record(ctx, who, "user", nil, v1)
+	require.NoError(t, err)
+	assert.Equal(t, ActionCreate, r.Action)
+	assert.Equal(t, "7", r.Key)
+	assert.Len(t, r.Changes, 3)
+
+	v2 := *v1
+	v2.Name = "joseph"
+	v2.Password = "hunter2"
+	r, err = a.Record(ctx, who, "user", v1, &v2)
+	require.NoError(t, err)
+	require.Len(t, r.Changes, 2)
+	assert.Equal(t, Change{Field: "name", From: "joe", To: "joseph"}, r.Changes[0])
+	assert.Equal(t, "password", r.Changes[1].Field)
+	assert.NotContains(t, r.Changes[1].From, "secret")
+	assert.NotEqual(t, r.Changes[1].From, r.Changes[1].To)
+
+	r, err = a.Record(ctx, who, "user", &v2, &v2)
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = a.Record(ctx, Who{Actor: "root"}, "user", &v2, nil)
+	require.NoError(t, err)
+	_, err = a.Record(ctx, who, "user", nil, &auditTestUser{ID: 8})
+	require.NoError(t, err)
+
+	history, err := a.History(ctx, Query{Entity: "user", Key: "7"})
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []string{ActionCreate, ActionUpdate, ActionDelete},
+		[]string{history[0].Action, history[1].Action, history[2].Action})
+	assert.Equal(t, "joseph", history[1].Changes[0].To)
+
+	history, err = a.History(ctx, Query{Entity: "user", Key: "7", Tenant: "acme", Since: history[1].Time})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, ActionUpdate, history[0].Action)
+
+	history, err = NewFileSink(filepath.Join(t.TempDir(), "none")).History(ctx, Query{Entity: "user", Key: "7"})
+	require.NoError(t, err)
+	assert.Nil(t, history)
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/NetCarrier/ncservice"
+)
+
+// DB is satisfied by *sql.DB, *sql.Tx, *sqlx.DB and *sqlx.Tx
+type DB interface {
+	ncservice.Execer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// SQLSink stores records in a table with the columns
+//
+//	at         datetime
+//	actor      varchar
+//	tenant     varchar
+//	entity     varchar
+//	entity_key varchar
+//	action     varchar
+//	changes    text, the changes as JSON
+//
+// An index on entity, entity_key and at makes reading history fast.
+type SQLSink struct {
+	DB      DB
+	Dialect ncservice.Dialect
+	Table   string
+}
+
+// sqlRecord is a record as stored in the table
+type sqlRecord struct {
+	At        time.Time `gorm:"column:at"`
+	Actor     string    `gorm:"column:actor"`
+	Tenant    string    `gorm:"column:tenant"`
+	Entity    string    `gorm:"column:entity"`
+	EntityKey string    `gorm:"column:entity_key"`
+	Action    string    `gorm:"column:action"`
+	Changes   string    `gorm:"column:changes"`
+}
+
+func (s SQLSink) Write(ctx context.Context, r Record) error {
+	changes, err := json.Marshal(r.Changes)
+	if err != nil {
+		return err
+	}
+	stmt, err := s.Dialect.Insert(s.Table, &sqlRecord{
+		At:        r.Time,
+		Actor:     r.Actor,
+		Tenant:    r.Tenant,
+		Entity:    r.Entity,
+		EntityKey: r.Key,
+		Action:    r.Action,
+		Changes:   string(changes),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, stmt.SQL, stmt.Args...)
+	return err
+}
+
+func (s SQLSink) History(ctx context.Context, q Query) ([]Record, error) {
+	stmt := s.historyStatement(q)
+	rows, err := s.DB.QueryContext(ctx, stmt.SQL, stmt.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found, err := ncservice.ScanRows[sqlRecord](rows, ncservice.ScanOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromSQL(found)
+}
+
+func fromSQL(found []sqlRecord) ([]Record, error) {
+	records := make([]Record, len(found))
+	for i, row := range found {
+		records[i] = Record{
+			Time:   row.At,
+			Actor:  row.Actor,
+			Tenant: row.Tenant,
+			Entity: row.Entity,
+			Key:    row.EntityKey,
+			Action: row.Action,
+		}
+		if err := json.Unmarshal([]byte(row.Changes), &records[i].Changes); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+var sqlColumns = []string{"at", "actor", "tenant", "entity", "entity_key", "action", "changes"}
+
+func (s SQLSink) historyStatement(q Query) ncservice.Statement {
+	d := s.Dialect
+	var args []any
+	cond := func(col string, op string, v any) string {
+		args = append(args, v)
+		return " AND " + d.Quote(col) + " " + op + " " + d.Placeholder(len(args))
+	}
+	query := "SELECT "
+	if q.Limit > 0 && d == ncservice.SQLServer {
+		query += "TOP " + strconv.Itoa(q.Limit) + " "
+	}
+	for i, col := range sqlColumns {
+		if i > 0 {
+			query += ", "
+		}
+		query += d.Quote(col)
+	}
+	query += " FROM " + d.Quote(s.Table) + " WHERE " + d.Quote("entity") + " = " + d.Placeholder(1)
+	args = append(args, q.Entity)
+	query += cond("entity_key", "=", q.Key)
+	if q.Tenant != "" {
+		query += cond("tenant", "=", q.Tenant)
+	}
+	if !q.Since.IsZero() {
+		query += cond("at", ">=", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query += cond("at", "<", q.Until)
+	}
+	query += " ORDER BY " + d.Quote("at")
+	if q.Limit > 0 && d != ncservice.SQLServer {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+	return ncservice.Statement{SQL: query, Args: args}
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/NetCarrier/ncservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingDB struct {
+	query string
+	args  []any
+}
+
+func (db *recordingDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db.query = query
+	db.args = args
+	return nil, nil
+}
+
+func (db *recordingDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	panic("not used")
+}
+
+func TestSQLSinkWrite(t *testing.T) {
+	db := &recordingDB{}
+	s := SQLSink{DB: db, Dialect: ncservice.MySQL, Table: "audit"}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := s.Write(context.Background(), Record{
+		Time: at, Actor: "admin", Entity: "user", Key: "7", Action: ActionUpdate,
+		Changes: []Change{{Field: "name", From: "joe", To: "joseph"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `audit` (`at`, `actor`, `tenant`, `entity`, `entity_key`, `action`, `changes`)"+
+		" VALUES (?, ?, ?, ?, ?, ?, ?)", db.query)
+	assert.Equal(t, []any{at, "admin", "", "user", "7", ActionUpdate,
+		`[{"field":"name","from":"joe","to":"joseph"}]`}, db.args)
+}
+
+func TestSQLSinkHistory(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := SQLSink{Dialect: ncservice.SQLServer, Table: "audit"}.historyStatement(
+		Query{Entity: "user", Key: "7", Tenant: "acme", Since: since, Limit: 10})
+	assert.Equal(t, "SELECT TOP 10 [at], [actor], [tenant], [entity], [entity_key], [action], [changes] FROM [audit]"+
+		" WHERE [entity] = @p1 AND [entity_key] = @p2 AND [tenant] = @p3 AND [at] >= @p4 ORDER BY [at]", stmt.SQL)
+	assert.Equal(t, []any{"user", "7", "acme", since}, stmt.Args)
+
+	stmt = SQLSink{Dialect: ncservice.MySQL, Table: "audit"}.historyStatement(Query{Entity: "user", Key: "7", Limit: 5})
+	assert.Equal(t, "SELECT `at`, `actor`, `tenant`, `entity`, `entity_key`, `action`, `changes` FROM `audit`"+
+		" WHERE `entity` = ? AND `entity_key` = ? ORDER BY `at` LIMIT 5", stmt.SQL)
+
+	records, err := fromSQL([]sqlRecord{{At: since, Actor: "admin", EntityKey: "7", Changes: `[{"field":"name","from":1,"to":2}]`}})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{{Time: since, Actor: "admin", Key: "7",
+		Changes: []Change{{Field: "name", From: float64(1), To: float64(2)}}}}, records)
+}
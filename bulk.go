@@ -0,0 +1,253 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// MaxParams is the most placeholders a single statement can have. SQL Server
+// allows 2100 but drivers send statements through sp_executesql whose own
+// @stmt and @params count toward the limit.
+func (d Dialect) MaxParams() int {
+	if d == SQLServer {
+		return 2098
+	}
+	return 65535
+}
+
+// maxRows is the most rows a single VALUES clause can have, 0 for no limit
+func (d Dialect) maxRows() int {
+	if d == SQLServer {
+		return 1000
+	}
+	return 0
+}
+
+// BulkInsert inserts many rows of the same struct with multi row VALUES
+// statements split into chunks that stay within the limits of the dialect
+type BulkInsert struct {
+	Dialect Dialect
+	Table   string
+	// Filter decides the columns to insert. Every row has to end up with the
+	// same columns as the first row.
+	Filter ValueFilter
+	// MaxRows optionally lowers the number of rows in a single statement
+	MaxRows int
+	// Isolate inserts the rows of a failed chunk one at a time to find the
+	// rows that fail and to insert the rows that do not. The failed chunk is
+	// replaced in the result by a chunk for each row that succeeds.
+	Isolate bool
+}
+
+// BulkChunk is a single statement inserting some of the rows
+type BulkChunk struct {
+	Statement
+	// Rows are the indexes of the rows in the slice given to the bulk insert
+	Rows []int
+}
+
+// RowErr is a failure of a single row in a bulk operation
+type RowErr struct {
+	Row int
+	Err error
+}
+
+func (e RowErr) Error() string {
+	return fmt.Sprintf("row %d. %s", e.Row, e.Err)
+}
+
+func (e RowErr) Unwrap() error {
+	return e.Err
+}
+
+// ChunkResult is the outcome of executing a single chunk
+type ChunkResult struct {
+	Rows     []int
+	Affected int64
+	Err      error
+}
+
+// BulkResult is the outcome of a bulk insert. Failed lists the rows that could
+// not be read and, with Isolate, the rows the database rejected.
+type BulkResult struct {
+	Chunks []ChunkResult
+	Failed []RowErr
+}
+
+// Affected is the total number of rows affected by all chunks
+func (r BulkResult) Affected() int64 {
+	var n int64
+	for _, c := range r.Chunks {
+		n += c.Affected
+	}
+	return n
+}
+
+// Err joins the errors of all failed rows and chunks or is nil when everything
+// was inserted
+func (r BulkResult) Err() error {
+	var errs []error
+	for _, c := range r.Chunks {
+		if c.Err != nil {
+			errs = append(errs, c.Err)
+		}
+	}
+	for _, f := range r.Failed {
+		errs = append(errs, f)
+	}
+	return errors.Join(errs...)
+}
+
+// Chunks builds the statements to insert rows which is a slice of structs or
+// pointers to structs. Rows that cannot be read or have different columns than
+// the first row are returned as failures and left out.
+func (b BulkInsert) Chunks(rows any) ([]BulkChunk, []RowErr, error) {
+	ref := reflect.ValueOf(rows)
+	if ref.Kind() != reflect.Slice {
+		return nil, nil, fmt.Errorf("bulk insert needs a slice, not %s", ref.Type())
+	}
+	var cols []string
+	var failed []RowErr
+	var vals [][]Value
+	var indexes []int
+	for i := range ref.Len() {
+		row := ref.Index(i)
+		if row.Kind() == reflect.Ptr && row.IsNil() {
+			failed = append(failed, RowErr{Row: i, Err: errors.New("nil row")})
+			continue
+		}
+		rowVals, err := writeValues(b.Table, row.Interface(), b.Filter)
+		if err != nil {
+			failed = append(failed, RowErr{Row: i, Err: err})
+			continue
+		}
+		rowCols := make([]string, len(rowVals))
+		for j, v := range rowVals {
+			rowCols[j] = v.Col
+		}
+		if cols == nil {
+			cols = rowCols
+		} else if !slices.Equal(cols, rowCols) {
+			failed = append(failed, RowErr{Row: i, Err: errors.New("columns differ from the first row")})
+			continue
+		}
+		vals = append(vals, rowVals)
+		indexes = append(indexes, i)
+	}
+	if len(vals) == 0 {
+		return nil, failed, nil
+	}
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("insert into %s has no columns", b.Table)
+	}
+	size, err := b.chunkSize(len(cols))
+	if err != nil {
+		return nil, nil, err
+	}
+	var chunks []BulkChunk
+	for start := 0; start < len(vals); start += size {
+		end := min(start+size, len(vals))
+		chunks = append(chunks, BulkChunk{
+			Statement: b.Dialect.insertRows(b.Table, cols, vals[start:end]),
+			Rows:      indexes[start:end],
+		})
+	}
+	return chunks, failed, nil
+}
+
+func (b BulkInsert) chunkSize(ncols int) (int, error) {
+	size := b.Dialect.MaxParams() / ncols
+	if size == 0 {
+		return 0, fmt.Errorf("%d columns is more than %s allows in a statement", ncols, b.Dialect)
+	}
+	if limit := b.Dialect.maxRows(); limit > 0 {
+		size = min(size, limit)
+	}
+	if b.MaxRows > 0 {
+		size = min(size, b.MaxRows)
+	}
+	return size, nil
+}
+
+func (d Dialect) insertRows(table string, cols []string, rows [][]Value) Statement {
+	b := &sqlBuilder{d: d}
+	b.write("INSERT INTO ", d.Quote(table), " (")
+	for i, c := range cols {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(c))
+	}
+	b.write(") VALUES ")
+	for i, row := range rows {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write("(")
+		for j, v := range row {
+			if j > 0 {
+				b.write(", ")
+			}
+			b.write(b.arg(v.Val))
+		}
+		b.write(")")
+	}
+	return b.statement()
+}
+
+// Exec inserts the rows chunk by chunk. Failed chunks do not stop the rest of
+// the chunks, check BulkResult.Err. Pass a transaction to have all the rows
+// inserted or none. The error is only for problems building the statements.
+func (b BulkInsert) Exec(ctx context.Context, db Execer, rows any) (BulkResult, error) {
+	chunks, failed, err := b.Chunks(rows)
+	if err != nil {
+		return BulkResult{}, err
+	}
+	result := BulkResult{Failed: failed}
+	for i, chunk := range chunks {
+		res, err := db.ExecContext(ctx, chunk.SQL, chunk.Args...)
+		if err != nil {
+			if b.Isolate {
+				b.isolate(ctx, db, rows, chunk.Rows, &result)
+				continue
+			}
+			result.Chunks = append(result.Chunks, ChunkResult{
+				Rows: chunk.Rows,
+				Err:  fmt.Errorf("insert of chunk %d into %s failed. %w", i, b.Table, err),
+			})
+			continue
+		}
+		result.Chunks = append(result.Chunks, ChunkResult{Rows: chunk.Rows, Affected: rowsAffected(res)})
+	}
+	return result, nil
+}
+
+// isolate inserts rows one at a time, each is recorded as a chunk of one row
+func (b BulkInsert) isolate(ctx context.Context, db Execer, rows any, indexes []int, result *BulkResult) {
+	ref := reflect.ValueOf(rows)
+	for _, i := range indexes {
+		stmt, err := b.Dialect.Insert(b.Table, ref.Index(i).Interface(), b.Filter)
+		if err != nil {
+			result.Failed = append(result.Failed, RowErr{Row: i, Err: err})
+			continue
+		}
+		res, err := db.ExecContext(ctx, stmt.SQL, stmt.Args...)
+		if err != nil {
+			result.Failed = append(result.Failed, RowErr{Row: i, Err: err})
+			continue
+		}
+		result.Chunks = append(result.Chunks, ChunkResult{Rows: []int{i}, Affected: rowsAffected(res)})
+	}
+}
+
+func rowsAffected(res sql.Result) int64 {
+	if res == nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
@@ -0,0 +1,109 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkExecer fails any statement with an argument of "bad"
+type bulkExecer struct {
+	queries []string
+}
+
+func (e *bulkExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	for _, a := range args {
+		if a == "bad" {
+			return nil, errors.New("rejected")
+		}
+	}
+	return driver.RowsAffected(strings.Count(query, "(") - 1), nil
+}
+
+func TestBulkChunks(t *testing.T) {
+	rows := []*sqlTestStruct{{ID: 1, Name: "a"}, nil, {ID: 3, Name: "c"}, {ID: 4, Name: "d"}}
+	b := BulkInsert{Dialect: SQLServer, Table: "users", Filter: FilterInclude([]string{"id", "name"}), MaxRows: 2}
+	chunks, failed, err := b.Chunks(rows)
+	require.NoError(t, err)
+	assert.Equal(t, []RowErr{{Row: 1, Err: errors.New("nil row")}}, failed)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "INSERT INTO [users] ([id], [name]) VALUES (@p1, @p2), (@p3, @p4)", chunks[0].SQL)
+	assert.Equal(t, []any{1, "a", 3, "c"}, chunks[0].Args)
+	assert.Equal(t, []int{0, 2}, chunks[0].Rows)
+	assert.Equal(t, "INSERT INTO [users] ([id], [name]) VALUES (@p1, @p2)", chunks[1].SQL)
+	assert.Equal(t, []int{3}, chunks[1].Rows)
+
+	// rows with different columns than the first are rejected
+	b = BulkInsert{Dialect: MySQL, Table: "users", Filter: FilterNotNil}
+	chunks, failed, err = b.Chunks([]sqlTestStruct{{ID: 1}, {ID: 2, Email: Ptr("x")}})
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, 1, failed[0].Row)
+
+	_, _, err = b.Chunks(sqlTestStruct{})
+	assert.Error(t, err)
+}
+
+func TestBulkChunkLimits(t *testing.T) {
+	b := BulkInsert{Dialect: SQLServer}
+	size, err := b.chunkSize(5)
+	require.NoError(t, err)
+	assert.Equal(t, 419, size)
+	assert.LessOrEqual(t, size*5+2, 2100)
+	size, err = b.chunkSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, size)
+	size, err = b.chunkSize(2098)
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	_, err = b.chunkSize(2099)
+	assert.Error(t, err)
+
+	b = BulkInsert{Dialect: MySQL}
+	size, err = b.chunkSize(5)
+	require.NoError(t, err)
+	assert.Equal(t, 13107, size)
+}
+
+func TestBulkExec(t *testing.T) {
+	rows := []sqlTestStruct{{ID: 1, Name: "a"}, {ID: 2, Name: "bad"}, {ID: 3, Name: "c"}, {ID: 4, Name: "d"}}
+	b := BulkInsert{Dialect: MySQL, Table: "users", Filter: FilterInclude([]string{"id", "name"}), MaxRows: 2}
+	ctx := context.Background()
+
+	db := &bulkExecer{}
+	result, err := b.Exec(ctx, db, rows)
+	require.NoError(t, err)
+	require.Len(t, result.Chunks, 2)
+	assert.EqualError(t, result.Chunks[0].Err, "insert of chunk 0 into users failed. rejected")
+	assert.Equal(t, int64(2), result.Affected())
+	assert.Error(t, result.Err())
+
+	b.Isolate = true
+	db = &bulkExecer{}
+	result, err = b.Exec(ctx, db, rows)
+	require.NoError(t, err)
+	assert.Len(t, db.queries, 4)
+	assert.Equal(t, int64(3), result.Affected())
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, 1, result.Failed[0].Row)
+	assert.EqualError(t, result.Err(), "row 1. rejected")
+}
+
+func TestBulkReadOnly(t *testing.T) {
+	rows := []struct {
+		ID      int    `gorm:"column:id;primaryKey"`
+		Name    string `gorm:"column:name"`
+		Created string `gorm:"column:created;->"`
+	}{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+	chunks, _, err := BulkInsert{Dialect: MySQL, Table: "t"}.Chunks(rows)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "INSERT INTO `t` (`id`, `name`) VALUES (?, ?), (?, ?)", chunks[0].SQL)
+}
@@ -0,0 +1,99 @@
+package ncservice
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CodecOptions configure how a Codec maps a struct. Everything is optional and
+// defaults to mapping to database columns.
+type CodecOptions struct {
+	ColumnMapper ColumnMapper
+	Prefixer     ColumnPrefixer
+	Reader       ValueReader
+	// Filter is applied to every call to Values in addition to the filter
+	// given to the call
+	Filter     ValueFilter
+	Converters *Converters
+}
+
+// Codec maps a struct type to and from values. Build one per type and share it,
+// it is safe to use from multiple goroutines.
+type Codec[T any] struct {
+	opts   CodecOptions
+	schema *schema
+}
+
+// NewCodec panics if T is not a struct as this is a programming error
+func NewCodec[T any](opts CodecOptions) *Codec[T] {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("codec type %s is not a struct", t))
+	}
+	if opts.ColumnMapper == nil {
+		opts.ColumnMapper = DatabaseColumns
+		if opts.Prefixer == nil {
+			opts.Prefixer = DatabasePrefix
+		}
+	}
+	if opts.Converters == nil {
+		opts.Converters = DefaultConverters
+	}
+	return &Codec[T]{
+		opts:   opts,
+		schema: schemaOf(t),
+	}
+}
+
+// New allocates a new, empty instance
+func (c *Codec[T]) New() *T {
+	return new(T)
+}
+
+// Values reads the fields of x that pass both the codec's filter and f
+func (c *Codec[T]) Values(x *T, f ValueFilter) ([]Value, error) {
+	filter := f
+	if c.opts.Filter != nil {
+		filter = FilterAnd(c.opts.Filter, filterOrAll(f))
+	}
+	return ReadValues(x, ReadValueOptions{
+		Filter:       filter,
+		ColumnMapper: c.opts.ColumnMapper,
+		Prefixer:     c.opts.Prefixer,
+		Reader:       c.opts.Reader,
+	})
+}
+
+// Set the fields of x from values likely obtained from Values
+func (c *Codec[T]) Set(x *T, values []Value) error {
+	return setValues(x, values, c.opts.ColumnMapper, c.opts.Prefixer, c.opts.Converters)
+}
+
+// Column maps a JSON field name, case insensitive, to its database column and
+// table. Table is empty for the struct's main table.
+func (c *Codec[T]) Column(jsonField string) (string, string, error) {
+	f, err := c.schema.fieldOfJson(jsonField)
+	if err != nil {
+		return "", "", err
+	}
+	return f.column, f.table, nil
+}
+
+// Field maps a database column to its JSON field name
+func (c *Codec[T]) Field(column string) (string, error) {
+	f, err := c.schema.fieldOfColumn(column)
+	if err != nil {
+		return "", err
+	}
+	return f.json, nil
+}
+
+// Mappings lists how every field is addressed in the API and the database
+func (c *Codec[T]) Mappings() []FieldMapping {
+	return c.schema.mappings()
+}
+
+// Keys reads the primary key values of x in the order they are declared
+func (c *Codec[T]) Keys(x *T) ([]Value, error) {
+	return KeyValues(x)
+}
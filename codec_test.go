@@ -0,0 +1,80 @@
+package ncservice
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	type user struct {
+		TenantId int     `json:"tenantId" gorm:"column:tm_id;primaryKey"`
+		UserId   int     `json:"userId" gorm:"column:us_id;primaryKey"`
+		Name     string  `json:"name" gorm:"column:us_name"`
+		Email    *string `json:"email" gorm:"column:us_email"`
+		Secret   string  `json:"secret" gorm:"column:us_secret" password:"true"`
+	}
+	c := NewCodec[user](CodecOptions{
+		Filter: FilterExclude([]string{"us_secret"}),
+	})
+	x := c.New()
+	require.NoError(t, c.Set(x, []Value{
+		{Col: "tm_id", Val: 1},
+		{Col: "us_id", Val: []byte("2")},
+		{Col: "us_name", Val: "joe"},
+		{Col: "us_secret", Val: "shh"},
+	}))
+	assert.Equal(t, &user{TenantId: 1, UserId: 2, Name: "joe", Secret: "shh"}, x)
+
+	vals, err := c.Values(x, FilterNotNil)
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "tm_id", Val: 1}, {Col: "us_id", Val: 2}, {Col: "us_name", Val: "joe"}}, vals)
+
+	keys, err := c.Keys(x)
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "tm_id", Val: 1}, {Col: "us_id", Val: 2}}, keys)
+
+	col, table, err := c.Column("EMAIL")
+	require.NoError(t, err)
+	assert.Equal(t, "us_email", col)
+	assert.Equal(t, "", table)
+	_, _, err = c.Column("nope")
+	assert.Error(t, err)
+
+	fld, err := c.Field("us_name")
+	require.NoError(t, err)
+	assert.Equal(t, "name", fld)
+	_, err = c.Field("nope")
+	assert.Error(t, err)
+}
+
+func TestCodecApi(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id" gorm:"column:id"`
+		Name string `json:"name" gorm:"column:name"`
+	}
+	c := NewCodec[item](CodecOptions{
+		ColumnMapper: ApiColumns,
+		Prefixer:     ApiPrefix,
+		Reader: func(v any, fld reflect.StructField) (any, error) {
+			if s, ok := v.(string); ok {
+				return s + "!", nil
+			}
+			return v, nil
+		},
+	})
+	vals, err := c.Values(&item{ID: 1, Name: "x"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}, {Col: "name", Val: "x!"}}, vals)
+
+	_, err = c.Keys(&item{})
+	assert.Error(t, err)
+}
+
+func TestCodecNotStruct(t *testing.T) {
+	assert.Panics(t, func() {
+		NewCodec[int](CodecOptions{})
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/NetCarrier/ncservice"
@@ -212,6 +213,38 @@ func (f crudField) JsonTags(typ string) string {
 	return tags
 }
 
+// ConstraintTags carries YANG length, range and pattern restrictions to the
+// runtime validator, ncservice.Validate
+func (f crudField) ConstraintTags() string {
+	var tags string
+	var lengths []string
+	for _, r := range f.Def.Type().Length() {
+		lengths = append(lengths, r.String())
+	}
+	if len(lengths) > 0 {
+		tags += fmt.Sprintf(` length:"%s"`, strings.Join(lengths, ","))
+	}
+	var ranges []string
+	for _, r := range f.Def.Type().Range() {
+		ranges = append(ranges, r.String())
+	}
+	if len(ranges) > 0 {
+		tags += fmt.Sprintf(` range:"%s"`, strings.Join(ranges, ","))
+	}
+	var patterns []string
+	for _, p := range f.Def.Type().Patterns() {
+		if p.Inverted() || strings.Contains(p.Pattern, "`") {
+			slog.Error("cannot place regex in struct tag so it will not be validated", "regex", p.Pattern)
+			continue
+		}
+		patterns = append(patterns, p.Pattern)
+	}
+	if len(patterns) > 0 {
+		tags += " pattern:" + strconv.Quote(strings.Join(patterns, "\n"))
+	}
+	return tags
+}
+
 func (f crudField) ShowTag() string {
 	if hasExtension(f.Def, "showFull") {
 		return ` show:"full"`
@@ -219,6 +252,15 @@ func (f crudField) ShowTag() string {
 	return ""
 }
 
+// SearchTag marks fields that cannot be used in filter expressions for the
+// runtime filter parser, ncservice.ParseWhere
+func (f crudField) SearchTag() string {
+	if !f.IsSearchable() {
+		return ` search:"no"`
+	}
+	return ""
+}
+
 func (f crudField) Optional(typ string) bool {
 	// for key is not automatically generated, we must include it in the creation process
 	if typ == "create" && f.IsKey() {
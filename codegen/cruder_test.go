@@ -62,8 +62,12 @@ func TestCrudItem(t *testing.T) {
 	assert.Equal(t, "omitempty", z.BindingTags("create"))
 	og := c.Entries[0].fields[2]
 	assert.Equal(t, "Original Gangster. Supported regular expressions: [A-Z+]. Allowed string length: 3..5", og.Description())
+	assert.Equal(t, ` length:"3..5" pattern:"[A-Z+]"`, og.ConstraintTags())
 	n := c.Entries[0].fields[3]
 	assert.Equal(t, "Number. Allowed number ranges: 10..500", n.Description())
+	assert.Equal(t, ` range:"10..500"`, n.ConstraintTags())
+	assert.Equal(t, "", x.ConstraintTags())
+	assert.Equal(t, "", x.SearchTag())
 	f := c.Entries[0].fields[4]
 	assert.Equal(t, "[]string", f.GoType())
 }
@@ -0,0 +1,188 @@
+package ncservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LastModifiedField is the JSON name of the field used to detect concurrent
+// updates, the same leaf codegen looks for
+const LastModifiedField = "lastModified"
+
+// LastModifiedPrecision is what new lastModified times are truncated to so they
+// compare equal after a round trip through the database. The default suits MySQL
+// DATETIME which stores whole seconds, set time.Microsecond for DATETIME(6) or
+// SQL Server datetime2 columns.
+var LastModifiedPrecision = time.Second
+
+// lastModifiedOf finds the lastModified field which can be a time or an integer
+// version that is incremented
+func lastModifiedOf(t reflect.Type) (*fieldSchema, error) {
+	f, err := schemaOf(t).fieldOfJson(LastModifiedField)
+	if err != nil {
+		return nil, fmt.Errorf("%s has no %s field. %w", t, LastModifiedField, err)
+	}
+	base := baseType(f.Type)
+	if base != reflect.TypeFor[time.Time]() && !isInt(base) && !isUint(base) {
+		return nil, fmt.Errorf("%s field %s must be a time or an integer", LastModifiedField, f.Name)
+	}
+	return f, nil
+}
+
+func baseType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// nextModified is the value lastModified is bumped to, always after the
+// current value which can be a nil pointer
+func nextModified(f *fieldSchema, cur reflect.Value) (reflect.Value, error) {
+	cur = reflect.Indirect(cur)
+	var next any
+	switch base := baseType(f.Type); {
+	case base == reflect.TypeFor[time.Time]():
+		var t time.Time
+		if cur.IsValid() {
+			t = cur.Interface().(time.Time)
+		}
+		now := time.Now().UTC().Truncate(LastModifiedPrecision)
+		if !now.After(t) {
+			now = t.Add(LastModifiedPrecision)
+		}
+		next = now
+	case !cur.IsValid():
+		next = 1
+	case isUint(base):
+		next = cur.Uint() + 1
+	default:
+		next = cur.Int() + 1
+	}
+	return DefaultConverters.Convert(next, f.Type)
+}
+
+// UpdateIfUnmodified updates the non-key fields of h that pass the filter only
+// if the row's lastModified still has the value in h. On success lastModified is
+// bumped in both the row and h. ErrConflict is returned when no row matched
+// because it was changed or deleted since it was read.
+func UpdateIfUnmodified(ctx context.Context, db Execer, d Dialect, table string, h any, f ValueFilter) error {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return errors.New("UpdateIfUnmodified: target must be a pointer to a struct")
+	}
+	lm, err := lastModifiedOf(ref.Type())
+	if err != nil {
+		return err
+	}
+	keys, err := rowKeys(h)
+	if err != nil {
+		return err
+	}
+	vals, err := writeValues(table, h, FilterAnd(FilterNoKeys(h), filterOrAll(f), func(v Value, _ reflect.StructField) bool {
+		return v.Col != lm.column
+	}))
+	if err != nil {
+		return err
+	}
+	field, err := fieldByIndexAlloc(ref.Elem(), lm.index)
+	if err != nil {
+		return err
+	}
+	var cur any
+	if !(field.Kind() == reflect.Ptr && field.IsNil()) {
+		cur = field.Interface()
+	}
+	next, err := nextModified(lm, field)
+	if err != nil {
+		return err
+	}
+	vals = append(vals, Value{Col: lm.column, Val: next.Interface()})
+	stmt, err := d.update(table, vals, keys)
+	if err != nil {
+		return err
+	}
+	if cur == nil {
+		stmt.SQL += " AND " + d.Quote(lm.column) + " IS NULL"
+	} else {
+		stmt.Args = append(stmt.Args, cur)
+		stmt.SQL += " AND " + d.Quote(lm.column) + " = " + d.Placeholder(len(stmt.Args))
+	}
+	res, err := db.ExecContext(ctx, stmt.SQL, stmt.Args...)
+	if err != nil {
+		return fmt.Errorf("update of %s failed. %w", table, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConflict
+	}
+	field.Set(next)
+	return nil
+}
+
+// ETag is a strong HTTP entity tag made from the lastModified field of h
+func ETag(h any) (string, error) {
+	ref := reflect.Indirect(reflect.ValueOf(h))
+	lm, err := lastModifiedOf(ref.Type())
+	if err != nil {
+		return "", err
+	}
+	var n int64
+	if v, valid := fieldByIndex(ref, lm.index); valid && !v.IsZero() {
+		v = reflect.Indirect(v)
+		switch {
+		case isInt(v.Type()):
+			n = v.Int()
+		case isUint(v.Type()):
+			n = int64(v.Uint())
+		default:
+			n = v.Interface().(time.Time).UnixNano()
+		}
+	}
+	return `"` + strconv.FormatInt(n, 36) + `"`, nil
+}
+
+// SetETag sets the lastModified field of h from an ETag, typically from an
+// If-Match header, so UpdateIfUnmodified only succeeds when the client had
+// the latest version. A malformed ETag is a user error.
+func SetETag(h any, etag string) error {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return errors.New("SetETag: target must be a pointer to a struct")
+	}
+	lm, err := lastModifiedOf(ref.Type())
+	if err != nil {
+		return err
+	}
+	tag := strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	n, err := strconv.ParseInt(strings.Trim(tag, `"`), 36, 64)
+	if err != nil {
+		return ErrUserf("invalid etag %s", etag)
+	}
+	field, err := fieldByIndexAlloc(ref.Elem(), lm.index)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		field.Set(reflect.Zero(lm.Type))
+		return nil
+	}
+	var val any = n
+	if baseType(lm.Type) == reflect.TypeFor[time.Time]() {
+		val = time.Unix(0, n).UTC()
+	}
+	v, err := DefaultConverters.Convert(val, lm.Type)
+	if err != nil {
+		return ErrUserf("invalid etag %s. %s", etag, err)
+	}
+	field.Set(v)
+	return nil
+}
@@ -0,0 +1,111 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type concurrencyTestStruct struct {
+	ID           int        `json:"id" gorm:"column:id;primaryKey"`
+	Name         string     `json:"name" gorm:"column:name"`
+	LastModified *time.Time `json:"lastModified" gorm:"column:last_modified"`
+}
+
+type versionTestStruct struct {
+	ID      int    `json:"id" gorm:"column:id;primaryKey"`
+	Version uint64 `json:"lastModified" gorm:"column:version"`
+}
+
+// affectingExecer reports a fixed number of affected rows
+type affectingExecer struct {
+	query    string
+	args     []any
+	affected int64
+}
+
+func (e *affectingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	e.query = query
+	e.args = args
+	return driver.RowsAffected(e.affected), nil
+}
+
+func TestUpdateIfUnmodified(t *testing.T) {
+	ctx := context.Background()
+	read := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	x := &concurrencyTestStruct{ID: 1, Name: "joe", LastModified: &read}
+
+	db := &affectingExecer{affected: 1}
+	require.NoError(t, UpdateIfUnmodified(ctx, db, MySQL, "users", x, nil))
+	assert.Equal(t, "UPDATE `users` SET `name` = ?, `last_modified` = ? WHERE `id` = ? AND `last_modified` = ?", db.query)
+	assert.Equal(t, "joe", db.args[0])
+	assert.Equal(t, &read, db.args[3])
+	assert.True(t, x.LastModified.After(read))
+	assert.Zero(t, x.LastModified.Nanosecond())
+	assert.Equal(t, *x.LastModified, *(db.args[1].(*time.Time)))
+
+	db = &affectingExecer{}
+	bumped := *x.LastModified
+	err := UpdateIfUnmodified(ctx, db, SQLServer, "users", x, nil)
+	assert.True(t, errors.Is(err, ErrConflict))
+	assert.Equal(t, bumped, *x.LastModified)
+
+	x.LastModified = nil
+	require.NoError(t, UpdateIfUnmodified(ctx, &affectingExecer{affected: 1}, SQLServer, "users", x, nil))
+	assert.NotNil(t, x.LastModified)
+
+	v := &versionTestStruct{ID: 1, Version: 7}
+	db = &affectingExecer{affected: 1}
+	require.NoError(t, UpdateIfUnmodified(ctx, db, SQLServer, "things", v, nil))
+	assert.Equal(t, "UPDATE [things] SET [version] = @p1 WHERE [id] = @p2 AND [version] = @p3", db.query)
+	assert.Equal(t, []any{uint64(8), 1, uint64(7)}, db.args)
+	assert.Equal(t, uint64(8), v.Version)
+
+	assert.Error(t, UpdateIfUnmodified(ctx, db, MySQL, "x", &sqlTestStruct{}, nil))
+}
+
+func TestUpdateIfUnmodifiedReadOnly(t *testing.T) {
+	x := &struct {
+		ID      int    `gorm:"column:id;primaryKey"`
+		Name    string `gorm:"column:name"`
+		Created string `gorm:"column:created;->"`
+		Version int    `json:"lastModified" gorm:"column:version"`
+	}{ID: 1, Name: "joe", Version: 2}
+	db := &affectingExecer{affected: 1}
+	require.NoError(t, UpdateIfUnmodified(context.Background(), db, MySQL, "t", x, nil))
+	assert.Equal(t, "UPDATE `t` SET `name` = ?, `version` = ? WHERE `id` = ? AND `version` = ?", db.query)
+}
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	x := &concurrencyTestStruct{ID: 1, LastModified: &modified}
+	etag, err := ETag(x)
+	require.NoError(t, err)
+	assert.Equal(t, `"`, etag[:1])
+
+	var y concurrencyTestStruct
+	require.NoError(t, SetETag(&y, "W/"+etag))
+	assert.True(t, modified.Equal(*y.LastModified))
+
+	require.NoError(t, SetETag(&y, `"0"`))
+	assert.Nil(t, y.LastModified)
+	etag, err = ETag(y)
+	require.NoError(t, err)
+	assert.Equal(t, `"0"`, etag)
+
+	v := versionTestStruct{Version: 36}
+	etag, err = ETag(v)
+	require.NoError(t, err)
+	assert.Equal(t, `"10"`, etag)
+	require.NoError(t, SetETag(&v, `"z"`))
+	assert.Equal(t, uint64(35), v.Version)
+
+	err = SetETag(&v, `"not valid!"`)
+	assert.True(t, errors.Is(err, ErrUser))
+}
@@ -0,0 +1,344 @@
+package ncservice
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Converter converts a value to the type of a field. Return false when the
+// combination of types is not handled so the next converter is tried. The
+// value returned must be assignable or convertible to the target type.
+type Converter func(from any, to reflect.Type) (any, bool, error)
+
+// Converters is an ordered list of converters that are tried before the built in
+// conversions
+type Converters struct {
+	mu   sync.RWMutex
+	list []Converter
+}
+
+// DefaultConverters are used by SetValues and SetJsonValues
+var DefaultConverters = &Converters{}
+
+// RegisterConverter adds a converter to DefaultConverters
+func RegisterConverter(c Converter) {
+	DefaultConverters.Register(c)
+}
+
+// Register adds a converter. Converters registered later are tried first so they
+// can override earlier ones.
+func (c *Converters) Register(conv Converter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = append(c.list, conv)
+}
+
+// Convert a value to the given type trying registered converters first and
+// then the built in conversions which handle:
+//
+//	[]byte and string to string types, numbers, bools and time.Time
+//	numbers to bools like MySQL's tinyint(1)
+//	sql.NullString and other driver.Valuers to their value
+//	nil and pointers to pointers and back
+//	sql.Scanner, encoding.TextUnmarshaler and json.Unmarshaler types
+//	JSON text and decoded JSON to slices, maps and structs
+func (c *Converters) Convert(from any, to reflect.Type) (reflect.Value, error) {
+	if c != nil {
+		c.mu.RLock()
+		list := c.list
+		c.mu.RUnlock()
+		for _, conv := range slices.Backward(list) {
+			out, handled, err := conv(from, to)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if handled {
+				return assignable(out, to)
+			}
+		}
+	}
+	if from == nil {
+		return reflect.Zero(to), nil
+	}
+	fromVal := reflect.ValueOf(from)
+	fromType := fromVal.Type()
+	if fromType == to {
+		return fromVal, nil
+	}
+	if fromType.ConvertibleTo(to) && !lossyConversion(fromType, to) {
+		if err := checkOverflow(fromVal, to); err != nil {
+			return reflect.Value{}, err
+		}
+		return fromVal.Convert(to), nil
+	}
+	if fromVal.Kind() == reflect.Ptr {
+		if fromVal.IsNil() {
+			return reflect.Zero(to), nil
+		}
+		return c.Convert(fromVal.Elem().Interface(), to)
+	}
+	if valuer, ok := from.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return c.Convert(v, to)
+	}
+	if to.Kind() == reflect.Ptr {
+		v, err := c.Convert(from, to.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(to.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	}
+	if reflect.PointerTo(to).Implements(scannerType) {
+		p := reflect.New(to)
+		if err := p.Interface().(sql.Scanner).Scan(from); err != nil {
+			return reflect.Value{}, err
+		}
+		return p.Elem(), nil
+	}
+	return convertBuiltin(fromVal, to)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// lossyConversion are conversions reflect allows but would mangle the value
+// like int 65 to string "A" or float 1.5 to int 1
+func lossyConversion(from reflect.Type, to reflect.Type) bool {
+	switch {
+	case isInt(from) || isUint(from):
+		return to.Kind() == reflect.String
+	case isFloat(from):
+		return isInt(to) || isUint(to)
+	}
+	return false
+}
+
+// checkOverflow errors when a number does not fit in an integer type instead of
+// letting it wrap around like int 300 to int8 44
+func checkOverflow(from reflect.Value, to reflect.Type) error {
+	if !isInt(to) && !isUint(to) {
+		return nil
+	}
+	target := reflect.Zero(to)
+	var overflows bool
+	switch {
+	case isInt(from.Type()):
+		n := from.Int()
+		if isInt(to) {
+			overflows = target.OverflowInt(n)
+		} else {
+			overflows = n < 0 || target.OverflowUint(uint64(n))
+		}
+	case isUint(from.Type()):
+		n := from.Uint()
+		if isInt(to) {
+			overflows = n > math.MaxInt64 || target.OverflowInt(int64(n))
+		} else {
+			overflows = target.OverflowUint(n)
+		}
+	case isFloat(from.Type()):
+		n := from.Float()
+		if isInt(to) {
+			overflows = n < math.MinInt64 || n >= math.MaxInt64 || target.OverflowInt(int64(n))
+		} else {
+			overflows = n < 0 || n >= math.MaxUint64 || target.OverflowUint(uint64(n))
+		}
+	default:
+		return nil
+	}
+	if overflows {
+		return fmt.Errorf("%v is out of range for %s", from.Interface(), to)
+	}
+	return nil
+}
+
+func convertBuiltin(from reflect.Value, to reflect.Type) (reflect.Value, error) {
+	out := reflect.New(to).Elem()
+	switch {
+	case from.Kind() == reflect.String || isBytes(from.Type()):
+		return convertText(from, to)
+	case isInt(from.Type()) || isUint(from.Type()) || isFloat(from.Type()):
+		var n float64
+		switch {
+		case isInt(from.Type()):
+			n = float64(from.Int())
+		case isUint(from.Type()):
+			n = float64(from.Uint())
+		default:
+			n = from.Float()
+		}
+		switch {
+		case to.Kind() == reflect.Bool:
+			out.SetBool(n != 0)
+			return out, nil
+		case to.Kind() == reflect.String:
+			out.SetString(fmt.Sprint(from.Interface()))
+			return out, nil
+		case isInt(to) || isUint(to):
+			if err := checkOverflow(from, to); err != nil {
+				return reflect.Value{}, err
+			}
+			if n != math.Trunc(n) {
+				return reflect.Value{}, fmt.Errorf("%v is not a whole number", from.Interface())
+			}
+			return from.Convert(to), nil
+		}
+	case from.Type() == timeType && to.Kind() == reflect.String:
+		out.SetString(from.Interface().(time.Time).Format(time.RFC3339Nano))
+		return out, nil
+	case from.Kind() == reflect.Slice || from.Kind() == reflect.Map:
+		// likely decoded JSON like []any or map[string]any
+		if to.Kind() == reflect.Slice || to.Kind() == reflect.Map || to.Kind() == reflect.Struct {
+			data, err := json.Marshal(from.Interface())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if err := json.Unmarshal(data, out.Addr().Interface()); err != nil {
+				return reflect.Value{}, err
+			}
+			return out, nil
+		}
+	}
+	return reflect.Value{}, errNotConvertible(from.Type(), to)
+}
+
+// convertText handles text from either the database or JSON
+func convertText(from reflect.Value, to reflect.Type) (reflect.Value, error) {
+	s := from.String()
+	if isBytes(from.Type()) {
+		s = string(from.Bytes())
+	}
+	out := reflect.New(to).Elem()
+	switch {
+	case to == timeType:
+		t, err := parseTime(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.Set(reflect.ValueOf(t))
+		return out, nil
+	case reflect.PointerTo(to).Implements(textUnmarshalerType):
+		if err := out.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, err
+		}
+		return out, nil
+	case reflect.PointerTo(to).Implements(jsonUnmarshalerType):
+		quoted, _ := json.Marshal(s)
+		if err := out.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(quoted); err != nil {
+			return reflect.Value{}, err
+		}
+		return out, nil
+	case to.Kind() == reflect.String:
+		out.SetString(s)
+		return out, nil
+	case isInt(to):
+		n, err := strconv.ParseInt(s, 10, to.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetInt(n)
+		return out, nil
+	case isUint(to):
+		n, err := strconv.ParseUint(s, 10, to.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetUint(n)
+		return out, nil
+	case isFloat(to):
+		n, err := strconv.ParseFloat(s, to.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetFloat(n)
+		return out, nil
+	case to.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out.SetBool(b)
+		return out, nil
+	case to.Kind() == reflect.Slice || to.Kind() == reflect.Map || to.Kind() == reflect.Struct:
+		// columns that store JSON like gorm's serializer:json
+		if err := json.Unmarshal([]byte(s), out.Addr().Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return out, nil
+	}
+	return reflect.Value{}, errNotConvertible(from.Type(), to)
+}
+
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999", // MySQL and SQL Server DATETIME
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05",
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a recognized time format", s)
+}
+
+// assignable checks the value from a custom converter can be set on the target
+func assignable(v any, to reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		return reflect.Zero(to), nil
+	}
+	ref := reflect.ValueOf(v)
+	if ref.Type().AssignableTo(to) {
+		return ref, nil
+	}
+	if ref.Type().ConvertibleTo(to) {
+		return ref.Convert(to), nil
+	}
+	return reflect.Value{}, errNotConvertible(ref.Type(), to)
+}
+
+func errNotConvertible(from reflect.Type, to reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", from, to)
+}
+
+func isInt(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isFloat(t reflect.Type) bool {
+	return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
+
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
@@ -0,0 +1,122 @@
+package ncservice
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type convertTestEnum int
+
+const (
+	convertTestEnumOff convertTestEnum = iota
+	convertTestEnumOn
+)
+
+func (e *convertTestEnum) UnmarshalText(data []byte) error {
+	switch string(data) {
+	case "off":
+		*e = convertTestEnumOff
+	case "on":
+		*e = convertTestEnumOn
+	default:
+		return errors.New("bad enum " + string(data))
+	}
+	return nil
+}
+
+func TestConvert(t *testing.T) {
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		from     any
+		to       any
+		expected any
+	}{
+		{from: []byte("abc"), to: "", expected: "abc"},
+		{from: "42", to: 0, expected: 42},
+		{from: []byte("42"), to: int64(0), expected: int64(42)},
+		{from: "4.5", to: float64(0), expected: 4.5},
+		{from: float64(3), to: 0, expected: 3},
+		{from: int64(1), to: false, expected: true},
+		{from: int64(0), to: false, expected: false},
+		{from: []byte("1"), to: false, expected: true},
+		{from: 7, to: "", expected: "7"},
+		{from: "2024-03-01T12:30:00Z", to: time.Time{}, expected: when},
+		{from: []byte("2024-03-01 12:30:00"), to: time.Time{}, expected: when},
+		{from: sql.NullString{String: "x", Valid: true}, to: Ptr(""), expected: Ptr("x")},
+		{from: sql.NullString{}, to: Ptr(""), expected: (*string)(nil)},
+		{from: sql.NullInt64{Int64: 9, Valid: true}, to: Ptr(0), expected: Ptr(9)},
+		{from: "y", to: sql.NullString{}, expected: sql.NullString{String: "y", Valid: true}},
+		{from: "on", to: convertTestEnumOff, expected: convertTestEnumOn},
+		{from: []any{"a", "b"}, to: []string{}, expected: []string{"a", "b"}},
+		{from: `["a","b"]`, to: []string{}, expected: []string{"a", "b"}},
+		{from: Ptr("z"), to: "", expected: "z"},
+		{from: int64(127), to: int8(0), expected: int8(127)},
+		{from: float64(255), to: uint8(0), expected: uint8(255)},
+		{from: uint64(7), to: int16(0), expected: int16(7)},
+	}
+	for _, test := range tests {
+		to := reflect.TypeOf(test.to)
+		actual, err := DefaultConverters.Convert(test.from, to)
+		require.NoError(t, err, "%T -> %s", test.from, to)
+		assert.Equal(t, test.expected, actual.Interface(), "%T -> %s", test.from, to)
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	for _, test := range []struct {
+		from any
+		to   any
+	}{
+		{from: 1.5, to: 0},
+		{from: "abc", to: 0},
+		{from: "never", to: time.Time{}},
+		{from: "bad", to: convertTestEnumOff},
+		{from: true, to: time.Time{}},
+		{from: int64(300), to: int8(0)},
+		{from: float64(300), to: int8(0)},
+		{from: -1, to: uint(0)},
+		{from: float64(-1), to: uint32(0)},
+		{from: uint64(math.MaxUint64), to: int64(0)},
+		{from: 1e20, to: int64(0)},
+		{from: "300", to: int8(0)},
+	} {
+		_, err := DefaultConverters.Convert(test.from, reflect.TypeOf(test.to))
+		assert.Error(t, err, "%v -> %T", test.from, test.to)
+	}
+	_, err := DefaultConverters.Convert(int64(300), reflect.TypeFor[int8]())
+	assert.EqualError(t, err, "300 is out of range for int8")
+}
+
+func TestSetValuesConversionError(t *testing.T) {
+	x := struct {
+		Count int `gorm:"column:cnt"`
+	}{}
+	err := SetValues(&x, []Value{{Col: "cnt", Val: "many"}})
+	assert.ErrorContains(t, err, "field Count from column cnt")
+}
+
+func TestCustomConverter(t *testing.T) {
+	type upper string
+	c := &Converters{}
+	c.Register(func(from any, to reflect.Type) (any, bool, error) {
+		if s, ok := from.(string); ok && to == reflect.TypeOf(upper("")) {
+			return strings.ToUpper(s), true, nil
+		}
+		return nil, false, nil
+	})
+	actual, err := c.Convert("abc", reflect.TypeOf(upper("")))
+	assert.NoError(t, err)
+	assert.Equal(t, upper("ABC"), actual.Interface())
+
+	actual, err = c.Convert("abc", reflect.TypeOf(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", actual.Interface())
+}
@@ -1,9 +1,14 @@
 package ncservice
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
+	"time"
 )
 
 const GROUP_ALL = "all"
@@ -19,6 +24,7 @@ func ApiValues(h any, f ValueFilter) ([]Value, error) {
 		ReadValueOptions{
 			Filter:       f,
 			ColumnMapper: ApiColumns,
+			Prefixer:     ApiPrefix,
 		},
 	)
 }
@@ -29,6 +35,7 @@ func Values(h any, f ValueFilter) ([]Value, error) {
 		ReadValueOptions{
 			Filter:       f,
 			ColumnMapper: DatabaseColumns,
+			Prefixer:     DatabasePrefix,
 		},
 	)
 }
@@ -37,10 +44,20 @@ func DatabaseColumns(fld reflect.StructField) (string, bool) {
 	return getGormTag(fld.Tag.Get("gorm"), "column")
 }
 
+// DatabasePrefix is the column prefix gorm applies to fields of an embedded struct
+// from the embeddedPrefix tag
+func DatabasePrefix(fld reflect.StructField) string {
+	prefix, _ := getGormTag(fld.Tag.Get("gorm"), "embeddedPrefix")
+	return prefix
+}
+
 type ReadValueOptions struct {
 	Filter       ValueFilter
 	ColumnMapper ColumnMapper
-	Reader       ValueReader
+	// Prefixer is optional and gives the column prefix for fields inside nested
+	// structs
+	Prefixer ColumnPrefixer
+	Reader   ValueReader
 }
 
 func ApiColumns(fld reflect.StructField) (string, bool) {
@@ -52,65 +69,221 @@ func ApiColumns(fld reflect.StructField) (string, bool) {
 	return segs[0], true
 }
 
+// ApiPrefix addresses fields of a nested struct with a dotted path
+// like address.city. Embedded structs w/o a json name are flattened just like
+// encoding/json does.
+func ApiPrefix(fld reflect.StructField) string {
+	name, exists := ApiColumns(fld)
+	if !exists || name == "" {
+		return ""
+	}
+	return name + "."
+}
+
 type ColumnMapper func(fld reflect.StructField) (string, bool)
 
+// ColumnPrefixer gives the prefix for the columns of all the fields inside a
+// nested struct field
+type ColumnPrefixer func(fld reflect.StructField) string
+
 // f ValueFilter, getCol ColumnMapper
 func ReadValues(h any, opts ReadValueOptions) ([]Value, error) {
+	values, _, err := readFieldValues(h, opts)
+	return values, err
+}
+
+// readFieldValues is ReadValues that also returns the field of each value
+func readFieldValues(h any, opts ReadValueOptions) ([]Value, []*fieldSchema, error) {
 	var err error
 	ref := reflect.ValueOf(h)
 	if ref.Kind() == reflect.Ptr {
 		ref = ref.Elem()
 	}
-	t := ref.Type()
-	n := ref.NumField()
-	var values []Value
-	for i := range n {
-		fld := t.Field(i)
-		col, exists := opts.ColumnMapper(fld)
+	s := schemaOf(ref.Type())
+	column := columnResolver(opts.ColumnMapper, opts.Prefixer)
+	values := make([]Value, 0, len(s.fields))
+	var fields []*fieldSchema
+	for _, f := range s.fields {
+		col, exists := column(f)
 		if !exists {
 			continue
 		}
 		v := Value{
 			Col:   col,
-			Table: fld.Tag.Get("table"),
+			Table: f.table,
 		}
-		refval := ref.Field(i)
-		if !(refval.Kind() == reflect.Ptr && refval.IsNil()) {
+		refval, valid := fieldByIndex(ref, f.index)
+		if valid && !(refval.Kind() == reflect.Ptr && refval.IsNil()) {
 			val := refval.Interface()
 			if opts.Reader != nil {
-				if v.Val, err = opts.Reader(val, fld); err != nil {
-					return nil, err
+				if v.Val, err = opts.Reader(val, f.StructField); err != nil {
+					return nil, nil, err
 				}
 			} else {
 				v.Val = val
 			}
 		}
-		values = appendFiltered(values, v, fld, opts.Filter)
+		if opts.Filter == nil || opts.Filter(v, f.StructField) {
+			values = append(values, v)
+			fields = append(fields, f)
+		}
 	}
-	return values, nil
+	return values, fields, nil
 }
 
-func GetPrimaryKeyColumn(h any) []string {
-	var cols []string
-	ForEachGorm(reflect.ValueOf(h).Elem(), func(fld reflect.StructField, tag string, col string) bool {
-		if _, exists := getGormTag(tag, "primaryKey"); exists {
-			cols = append(cols, col)
+// structField is a leaf field of a struct, that is not a field that is itself
+// walked into because it is an embedded or nested struct
+type structField struct {
+	reflect.StructField
+	// index is the path from the root struct, the StructField.Index is only
+	// relative to the parent struct
+	index   []int
+	parents []reflect.StructField
+}
+
+func (sf structField) prefix(p ColumnPrefixer) string {
+	if p == nil {
+		return ""
+	}
+	var prefix string
+	for _, parent := range sf.parents {
+		prefix += p(parent)
+	}
+	return prefix
+}
+
+// jsonHidden tells if the field is inside a nested struct tagged `json:"-"`
+// which encoding/json leaves out along with all its fields
+func (sf structField) jsonHidden() bool {
+	for _, parent := range sf.parents {
+		if parent.Tag.Get("json") == "-" {
+			return true
 		}
+	}
+	return false
+}
+
+// structFields lists all the leaf fields of a struct, descending into embedded
+// structs. A struct that embeds itself through a pointer is not descended into
+// again.
+func structFields(t reflect.Type) []structField {
+	return appendStructFields(nil, t, nil, nil, []reflect.Type{t})
+}
+
+func appendStructFields(fields []structField, t reflect.Type, index []int, parents []reflect.StructField, path []reflect.Type) []structField {
+	for i := range t.NumField() {
+		fld := t.Field(i)
+		if !fld.IsExported() && !fld.Anonymous {
+			continue
+		}
+		fldIndex := append(slices.Clone(index), i)
+		if isNested(fld) {
+			nestedType := fld.Type
+			if nestedType.Kind() == reflect.Ptr {
+				nestedType = nestedType.Elem()
+			}
+			if slices.Contains(path, nestedType) {
+				continue
+			}
+			fldParents := append(slices.Clone(parents), fld)
+			fldPath := append(slices.Clone(path), nestedType)
+			fields = appendStructFields(fields, nestedType, fldIndex, fldParents, fldPath)
+			continue
+		}
+		if !fld.IsExported() {
+			continue
+		}
+		fields = append(fields, structField{
+			StructField: fld,
+			index:       fldIndex,
+			parents:     parents,
+		})
+	}
+	return fields
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isNested tells if a field is a struct whose fields should be read individually
+// instead of as a single value. Only anonymous embedded structs and fields
+// tagged gorm embedded are, other structs like JSON documents are single values.
+func isNested(fld reflect.StructField) bool {
+	t := fld.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	tag := fld.Tag.Get("gorm")
+	if _, embedded := getGormTag(tag, "embedded"); embedded {
 		return true
-	})
+	}
+	if !fld.Anonymous {
+		return false
+	}
+	if _, hasCol := getGormTag(tag, "column"); hasCol {
+		return false
+	}
+	// types that know how to encode themselves are single values like sql.NullString
+	if t.Implements(valuerType) || reflect.PointerTo(t).Implements(scannerType) ||
+		reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return false
+	}
+	return true
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// fieldByIndex is like reflect.Value.FieldByIndex but returns false instead of
+// panicking when a nested struct pointer is nil
+func fieldByIndex(ref reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && ref.Kind() == reflect.Ptr {
+			if ref.IsNil() {
+				return reflect.Value{}, false
+			}
+			ref = ref.Elem()
+		}
+		ref = ref.Field(x)
+	}
+	return ref, true
+}
+
+// fieldByIndexAlloc is like reflect.Value.FieldByIndex but allocates any nested
+// struct pointers that are nil along the way
+func fieldByIndexAlloc(ref reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && ref.Kind() == reflect.Ptr {
+			if ref.IsNil() {
+				if !ref.CanSet() {
+					return reflect.Value{}, fmt.Errorf("SetValues: cannot allocate field %s", ref.Type().String())
+				}
+				ref.Set(reflect.New(ref.Type().Elem()))
+			}
+			ref = ref.Elem()
+		}
+		ref = ref.Field(x)
+	}
+	return ref, nil
+}
+
+func GetPrimaryKeyColumn(h any) []string {
+	var cols []string
+	for _, f := range schemaOf(reflect.TypeOf(h)).keys {
+		cols = append(cols, f.column)
+	}
 	return cols
 }
 
 func ForEachGorm(ref reflect.Value, fn func(fld reflect.StructField, tag string, col string) bool) {
-	t := ref.Type()
-	for i := 0; i < ref.NumField(); i++ {
-		fld := t.Field(i)
-		tag := fld.Tag.Get("gorm")
-		col, exists := getGormTag(tag, "column")
-		if !exists {
+	for _, f := range schemaOf(ref.Type()).fields {
+		if !f.hasColumn {
 			continue
 		}
-		if !fn(fld, tag, col) {
+		if !fn(f.StructField, f.gorm, f.column) {
 			break
 		}
 	}
@@ -150,107 +323,96 @@ func getGormTag(tag string, target string) (string, bool) {
 
 // FieldToColumn maps a JSON field name to its corresponding database column name
 // using reflection to read the gorm:"column:xxx" tag from the provided struct.
+// Fields in nested structs are addressed with a dotted path like address.city.
 func FieldToColumn[T any](jsonField string) (string, string, error) {
-	var instance T
-	t := reflect.TypeOf(instance)
-	jsonFieldLc := strings.ToLower(jsonField)
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-
-		// Get the JSON tag to match against the input
-		jsonTag := field.Tag.Get("json")
-		if jsonTag == "" || jsonTag == "-" {
-			continue
-		}
-
-		// Parse JSON tag (format: "fieldName,omitempty" or just "fieldName")
-		jsonName := strings.ToLower(strings.Split(jsonTag, ",")[0])
-		if jsonName == jsonFieldLc {
-			// Found matching field, now extract the column name from gorm tag
-			gormTag := field.Tag.Get("gorm")
-			if gormTag == "" {
-				return "", "", fmt.Errorf("field %s has no gorm tag", jsonField)
-			}
+	f, err := schemaOf(reflect.TypeFor[T]()).fieldOfJson(jsonField)
+	if err != nil {
+		return "", "", err
+	}
+	return f.column, f.table, nil
+}
 
-			// Parse gorm tag to find column name using getGormTag helper
-			columnName, exists := getGormTag(gormTag, "column")
-			if !exists {
-				return "", "", fmt.Errorf("field %s has no column in gorm tag", jsonField)
-			}
-			table := field.Tag.Get("table")
-			return columnName, table, nil
-		}
+// ColumnToField is the reverse of FieldToColumn and maps a database column to
+// its JSON field name. Columns of fields tagged for another table can be
+// qualified with the table like voicemail.em_id.
+func ColumnToField[T any](column string) (string, error) {
+	f, err := schemaOf(reflect.TypeFor[T]()).fieldOfColumn(column)
+	if err != nil {
+		return "", err
 	}
+	return f.json, nil
+}
 
-	return "", "", fmt.Errorf("unknown field: %s", jsonField)
+// FieldMapping is how a single field is addressed in the API and in the database
+type FieldMapping struct {
+	Field  string `json:"field"`
+	Column string `json:"column"`
+	Table  string `json:"table,omitempty"`
+}
+
+// FieldMappings lists all the fields that have both a JSON name and a database
+// column in the order they are declared
+func FieldMappings[T any]() []FieldMapping {
+	return schemaOf(reflect.TypeFor[T]()).mappings()
 }
 
 // SetValues takes a list of values likely obtained from Values() and sets the corresponding
+// Values of fields tagged `encrypt:"true"` are decrypted with the DefaultEncryptor.
 func SetValues(h any, values []Value) error {
-	return setValues(h, values, DatabaseColumns)
+	return setValues(h, values, DatabaseColumns, DatabasePrefix, DefaultConverters)
 }
 
 // SetJsonValues takes a list of values likely obtained from JsonValues() and sets the corresponding
 func SetJsonValues(h any, values []Value) error {
-	return setValues(h, values, ApiColumns)
+	return setValues(h, values, ApiColumns, ApiPrefix, DefaultConverters)
 }
 
 // setValues takes a list of values likely obtained from Values() and sets the corresponding
-func setValues(h any, values []Value, getCol ColumnMapper) error {
+// Encrypted fields are decrypted unless the values are JSON from a client.
+func setValues(h any, values []Value, getCol ColumnMapper, getPrefix ColumnPrefixer, conv *Converters) error {
 	ref := reflect.ValueOf(h).Elem()
-	t := ref.Type()
-	for i := 0; i < ref.NumField(); i++ {
-		fld := t.Field(i)
-		col, exists := getCol(fld)
-		if !exists {
+	s := schemaOf(ref.Type())
+	byCol := s.columnIndex(getCol, getPrefix)
+	stored := mappingOf(getCol, getPrefix) != apiMapping
+	// first value for a column wins
+	done := make([]bool, len(s.fields))
+	for _, v := range values {
+		f, found := byCol[v.Col]
+		if !found || done[f.pos] {
 			continue
 		}
-		for _, v := range values {
-			if v.Col == col {
-				field := ref.Field(i)
-				if err := setValue(field, v); err != nil {
-					return err
-				}
-				break
+		done[f.pos] = true
+		if v.Val == nil {
+			// avoid allocating nested structs when there is nothing to set
+			continue
+		}
+		if stored {
+			decrypted, err := decryptValue(f, v)
+			if err != nil {
+				return err
 			}
+			v = decrypted
+		}
+		field, err := fieldByIndexAlloc(ref, f.index)
+		if err != nil {
+			return err
+		}
+		if err := setValue(field, f, v, conv); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 // setValue sets the value of a struct field using reflection, handling type conversion as needed.
-func setValue(to reflect.Value, from Value) error {
+func setValue(to reflect.Value, fld *fieldSchema, from Value, conv *Converters) error {
 	if !to.CanSet() {
-		return fmt.Errorf("SetValues: cannot set field %s", to.Type().String())
+		return fmt.Errorf("SetValues: cannot set field %s", fld.Name)
 	}
-
-	fromVal := reflect.ValueOf(from.Val)
-	if !fromVal.IsValid() {
-
-	} else if fromVal.Type().ConvertibleTo(to.Type()) {
-		to.Set(fromVal.Convert(to.Type()))
-	} else if fromVal.Kind() == reflect.Ptr {
-		eval := fromVal.Elem()
-		if eval.Type().ConvertibleTo(to.Type()) {
-			to.Set(eval.Convert(to.Type()))
-		} else {
-			return fmt.Errorf("SetValues: type not convertible for pointer: fieldType=%s, valueType=%s",
-				to.Type().String(),
-				fromVal.Type().String(),
-			)
-		}
-	} else if to.Kind() == reflect.Ptr {
-		pfield := reflect.New(to.Type().Elem())
-		if fromVal.Type().ConvertibleTo(to.Type().Elem()) {
-			pfield.Elem().Set(fromVal.Convert(to.Type().Elem()))
-			to.Set(pfield)
-		} else {
-			return fmt.Errorf("SetValues: type not convertible for pointer field: fieldType=%s, valueType=%s",
-				to.Type().String(),
-				fromVal.Type().String(),
-			)
-		}
+	v, err := conv.Convert(from.Val, to.Type())
+	if err != nil {
+		return fmt.Errorf("SetValues: field %s from column %s. %w", fld.Name, from.Col, err)
 	}
+	to.Set(v)
 	return nil
 }
@@ -272,3 +272,176 @@ func TestSetValues(t *testing.T) {
 	assert.EqualValues(t, "", ts2.Ignore)
 	assert.EqualValues(t, []string{"a", "b"}, ts2.Strs)
 }
+
+func TestEmbeddedValues(t *testing.T) {
+	type base struct {
+		ID   int    `json:"id" gorm:"column:id;primaryKey"`
+		Name string `json:"name" gorm:"column:name"`
+	}
+	type address struct {
+		City string  `json:"city" gorm:"column:city"`
+		Zip  *string `json:"zip" gorm:"column:zip"`
+	}
+	type testStruct struct {
+		base
+		Address  address  `json:"address" gorm:"embedded;embeddedPrefix:addr_"`
+		Billing  *address `json:"billing" gorm:"embedded;embeddedPrefix:bill_"`
+		Age      int      `json:"age" gorm:"column:age"`
+		internal string
+	}
+	ts := testStruct{
+		base:    base{ID: 1, Name: "Alice"},
+		Address: address{City: "Springfield", Zip: Ptr("12345")},
+		Age:     30,
+	}
+
+	t.Run("db", func(t *testing.T) {
+		values, err := Values(&ts, nil)
+		assert.NoError(t, err)
+		var cols []string
+		for _, v := range values {
+			cols = append(cols, v.Col)
+		}
+		assert.Equal(t, []string{"id", "name", "addr_city", "addr_zip", "bill_city", "bill_zip", "age"}, cols)
+		assert.Equal(t, 1, values[0].Val)
+		assert.Equal(t, "Springfield", values[2].Val)
+		assert.Nil(t, values[4].Val)
+
+		var copy testStruct
+		assert.NoError(t, SetValues(&copy, values))
+		assert.Equal(t, ts, copy)
+	})
+
+	t.Run("api", func(t *testing.T) {
+		values, err := ApiValues(&ts, nil)
+		assert.NoError(t, err)
+		var cols []string
+		for _, v := range values {
+			cols = append(cols, v.Col)
+		}
+		assert.Equal(t, []string{"id", "name", "address.city", "address.zip", "billing.city", "billing.zip", "age"}, cols)
+
+		values[4].Val = "Shelbyville"
+		var copy testStruct
+		assert.NoError(t, SetJsonValues(&copy, values))
+		assert.Equal(t, ts.base, copy.base)
+		assert.Equal(t, ts.Address, copy.Address)
+		assert.Equal(t, "Shelbyville", copy.Billing.City)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		values, err := Values(&ts, FilterOnlyKeys(ts))
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{{Col: "id", Val: 1}}, values)
+		assert.Equal(t, []string{"id"}, GetPrimaryKeyColumn(&ts))
+	})
+
+	t.Run("foreach", func(t *testing.T) {
+		var cols []string
+		ForEachGorm(reflect.ValueOf(ts), func(fld reflect.StructField, tag string, col string) bool {
+			cols = append(cols, col)
+			return true
+		})
+		assert.Equal(t, []string{"id", "name", "addr_city", "addr_zip", "bill_city", "bill_zip", "age"}, cols)
+	})
+}
+
+func TestNotEmbeddedValues(t *testing.T) {
+	type meta struct {
+		A int `json:"a"`
+	}
+	type node struct {
+		Name   string `json:"name" gorm:"column:name"`
+		Meta   meta   `json:"meta"`
+		Parent *node  `json:"parent"`
+	}
+	x := node{Name: "child", Meta: meta{A: 2}, Parent: &node{Name: "parent"}}
+	values, err := Values(&x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "name", Val: "child"}}, values)
+	values, err = ApiValues(&x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "name", Val: "child"}, {Col: "meta", Val: meta{A: 2}}, {Col: "parent", Val: x.Parent}}, values)
+
+	var copy node
+	assert.NoError(t, SetJsonValues(&copy, []Value{
+		{Col: "meta", Val: map[string]any{"a": 3}},
+		{Col: "parent", Val: map[string]any{"name": "p"}},
+	}))
+	assert.Equal(t, meta{A: 3}, copy.Meta)
+	assert.Equal(t, "p", copy.Parent.Name)
+}
+
+func TestJsonHiddenNested(t *testing.T) {
+	type inner struct {
+		Token string `json:"token" gorm:"column:token"`
+	}
+	type testStruct struct {
+		ID     int   `json:"id" gorm:"column:id"`
+		Secret inner `json:"-" gorm:"embedded"`
+	}
+	x := testStruct{ID: 1, Secret: inner{Token: "s3cret"}}
+	values, err := ApiValues(&x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}}, values)
+	values, err = Values(&x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}, {Col: "token", Val: "s3cret"}}, values)
+}
+
+func TestNestedFieldToColumn(t *testing.T) {
+	type address struct {
+		City   string `json:"city" gorm:"column:city"`
+		Street string `json:"street" gorm:"column:street"`
+	}
+	type base struct {
+		ID int `json:"id" gorm:"column:id;primaryKey"`
+	}
+	type testStruct struct {
+		base
+		Name    string  `json:"name" gorm:"column:name"`
+		Address address `json:"address" gorm:"embedded;embeddedPrefix:addr_"`
+		VmId    int     `json:"vmId" gorm:"column:id" table:"voicemail"`
+		NoJson  string  `gorm:"column:no_json"`
+	}
+
+	col, tbl, err := FieldToColumn[testStruct]("address.city")
+	assert.NoError(t, err)
+	assert.Equal(t, "addr_city", col)
+	assert.Equal(t, "", tbl)
+	col, _, err = FieldToColumn[testStruct]("id")
+	assert.NoError(t, err)
+	assert.Equal(t, "id", col)
+	_, _, err = FieldToColumn[testStruct]("address")
+	assert.Error(t, err)
+
+	tests := []struct {
+		column   string
+		expected string
+		err      bool
+	}{
+		{column: "addr_city", expected: "address.city"},
+		{column: "ADDR_STREET", expected: "address.street"},
+		{column: "id", expected: "id"},
+		{column: "voicemail.id", expected: "vmId"},
+		{column: "no_json", err: true},
+		{column: "nope", err: true},
+	}
+	for _, test := range tests {
+		fld, err := ColumnToField[testStruct](test.column)
+		if test.err {
+			assert.Error(t, err, test.column)
+		} else {
+			assert.NoError(t, err, test.column)
+			assert.Equal(t, test.expected, fld, test.column)
+		}
+	}
+
+	assert.Equal(t, []FieldMapping{
+		{Field: "id", Column: "id"},
+		{Field: "name", Column: "name"},
+		{Field: "address.city", Column: "addr_city"},
+		{Field: "address.street", Column: "addr_street"},
+		{Field: "vmId", Column: "id", Table: "voicemail"},
+	}, FieldMappings[testStruct]())
+}
@@ -0,0 +1,161 @@
+package ncservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Change operations use the same names as JSON Patch
+const (
+	ChangeAdd     = "add"
+	ChangeRemove  = "remove"
+	ChangeReplace = "replace"
+)
+
+// Change is the difference in a single field between two versions of a struct.
+// Fields in nested structs are addressed with a dotted path like address.city.
+// For slices, Added and Removed list the elements that differ regardless of
+// their position. Encoded as JSON, changes are in the order fields are declared
+// so the document is stable.
+type Change struct {
+	Field   string `json:"field"`
+	Column  string `json:"column,omitempty"`
+	Table   string `json:"table,omitempty"`
+	Op      string `json:"op"`
+	From    any    `json:"from"`
+	To      any    `json:"to"`
+	Added   []any  `json:"added,omitempty"`
+	Removed []any  `json:"removed,omitempty"`
+}
+
+// Diff compares two instances of the same struct type field by field. Either
+// can be a nil pointer to diff a created or deleted object. Fields are named by
+// their JSON name or by their column if they have no JSON name, fields with
+// neither are ignored.
+func Diff(before any, after any) ([]Change, error) {
+	bRef := reflect.ValueOf(before)
+	aRef := reflect.ValueOf(after)
+	if !bRef.IsValid() || !aRef.IsValid() {
+		return nil, errors.New("Diff: before and after must be structs or pointers to structs")
+	}
+	if bRef.Type() != aRef.Type() {
+		return nil, fmt.Errorf("Diff: cannot compare %s with %s", bRef.Type(), aRef.Type())
+	}
+	bRef = reflect.Indirect(bRef)
+	aRef = reflect.Indirect(aRef)
+	t := baseType(reflect.TypeOf(before))
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Diff: %s is not a struct", t)
+	}
+	var changes []Change
+	for _, f := range schemaOf(t).fields {
+		name := f.json
+		if !f.hasJson {
+			if !f.hasColumn {
+				continue
+			}
+			name = f.column
+		}
+		from := diffValue(bRef, f)
+		to := diffValue(aRef, f)
+		if diffEqual(from, to) {
+			continue
+		}
+		c := Change{
+			Field:  name,
+			Column: f.column,
+			Table:  f.table,
+			Op:     ChangeReplace,
+			From:   from,
+			To:     to,
+		}
+		switch {
+		case from == nil:
+			c.Op = ChangeAdd
+		case to == nil:
+			c.Op = ChangeRemove
+		}
+		if baseType(f.Type).Kind() == reflect.Slice && !isBytes(baseType(f.Type)) {
+			c.Added, c.Removed = diffElems(from, to)
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// diffValue reads a field following pointers, nil when unset. ref is invalid
+// when the whole struct is nil.
+func diffValue(ref reflect.Value, f *fieldSchema) any {
+	if !ref.IsValid() {
+		return nil
+	}
+	v, valid := fieldByIndex(ref, f.index)
+	if !valid {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func diffEqual(a any, b any) bool {
+	if at, isTime := a.(time.Time); isTime {
+		bt, isTime := b.(time.Time)
+		return isTime && at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// diffElems finds the elements only in from or only in to, counting duplicates
+func diffElems(from any, to any) ([]any, []any) {
+	counts := make(map[any]int)
+	var added, removed []any
+	if from != nil {
+		v := reflect.ValueOf(from)
+		for i := range v.Len() {
+			counts[elemKey(v.Index(i))]++
+		}
+	}
+	if to != nil {
+		v := reflect.ValueOf(to)
+		for i := range v.Len() {
+			k := elemKey(v.Index(i))
+			if counts[k] > 0 {
+				counts[k]--
+				continue
+			}
+			added = append(added, v.Index(i).Interface())
+		}
+	}
+	if from != nil {
+		v := reflect.ValueOf(from)
+		for i := range v.Len() {
+			if k := elemKey(v.Index(i)); counts[k] > 0 {
+				counts[k]--
+				removed = append(removed, v.Index(i).Interface())
+			}
+		}
+	}
+	return added, removed
+}
+
+// elemKey is a map key for a slice element. Composite elements are keyed by
+// their JSON so they compare by value like DeepEqual.
+func elemKey(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+		data, _ := json.Marshal(v.Interface())
+		return string(data)
+	}
+	return v.Interface()
+}
@@ -0,0 +1,50 @@
+package ncservice
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	before := patchTestStruct{
+		ID:         1,
+		Name:       "joe",
+		Tags:       []string{"a", "b", "b"},
+		Address:    patchTestAddress{City: "Springfield"},
+		TenantName: "acme",
+	}
+	after := before
+	after.Name = "joseph"
+	after.Email = Ptr("joe@example.com")
+	after.Tags = []string{"b", "c"}
+	after.Address.City = "Shelbyville"
+
+	_, err := Diff(before, &after)
+	require.Error(t, err)
+	changes, err := Diff(&before, &after)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Field: "name", Column: "name", Op: ChangeReplace, From: "joe", To: "joseph"},
+		{Field: "email", Column: "email", Op: ChangeAdd, To: "joe@example.com"},
+		{Field: "tags", Column: "tags", Op: ChangeReplace, From: before.Tags, To: after.Tags,
+			Added: []any{"c"}, Removed: []any{"a", "b"}},
+		{Field: "address.city", Column: "addr_city", Op: ChangeReplace, From: "Springfield", To: "Shelbyville"},
+	}, changes)
+
+	data, err := json.Marshal(changes[:2])
+	require.NoError(t, err)
+	assert.Equal(t, `[{"field":"name","column":"name","op":"replace","from":"joe","to":"joseph"},`+
+		`{"field":"email","column":"email","op":"add","from":null,"to":"joe@example.com"}]`, string(data))
+
+	changes, err = Diff(&before, (*patchTestStruct)(nil))
+	require.NoError(t, err)
+	assert.Len(t, changes, 6)
+	assert.Equal(t, Change{Field: "tenantName", Column: "tm_name", Table: "tenant", Op: ChangeRemove, From: "acme"}, changes[5])
+
+	changes, err = Diff(before, before)
+	require.NoError(t, err)
+	assert.Nil(t, changes)
+}
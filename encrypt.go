@@ -0,0 +1,288 @@
+package ncservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// encryptPrefix starts every ciphertext which is enc:<key id>:<base64 nonce and
+// sealed data> so the key can be found for decryption after keys are rotated
+const encryptPrefix = "enc:"
+
+// Encryptor encrypts the values of fields tagged `encrypt:"true"` with AES-GCM.
+// New values are encrypted with the primary key, values encrypted with any key
+// added can be decrypted so keys can be rotated by adding a new primary key and
+// re-encrypting with Reencrypt.
+type Encryptor struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+// DefaultEncryptor encrypts what Dialect, PlanInsert, PlanUpdate, BulkInsert and
+// UpdateIfUnmodified write and decrypts in SetValues and ScanRow. It has no
+// keys until AddKey is called.
+var DefaultEncryptor = &Encryptor{}
+
+// AddKey adds an AES key of 16, 24 or 32 bytes. The first key added becomes the
+// primary key.
+func (e *Encryptor) AddKey(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key id '%s'", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("key %s. %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys == nil {
+		e.keys = make(map[string]cipher.AEAD)
+	}
+	e.keys[id] = aead
+	if e.primary == "" {
+		e.primary = id
+	}
+	return nil
+}
+
+// SetPrimary makes an added key the one new values are encrypted with
+func (e *Encryptor) SetPrimary(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.keys[id]; !exists {
+		return fmt.Errorf("unknown key %s", id)
+	}
+	e.primary = id
+	return nil
+}
+
+// Primary is the id of the key new values are encrypted with
+func (e *Encryptor) Primary() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.primary
+}
+
+func (e *Encryptor) key(id string) (cipher.AEAD, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if id == "" {
+		return nil, errors.New("no encryption key")
+	}
+	aead, exists := e.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("unknown encryption key %s", id)
+	}
+	return aead, nil
+}
+
+// IsEncrypted tells if a value looks like it came from Encrypt
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptPrefix)
+}
+
+// KeyOf is the id of the key a value was encrypted with
+func KeyOf(ciphertext string) (string, error) {
+	id, _, found := strings.Cut(strings.TrimPrefix(ciphertext, encryptPrefix), ":")
+	if !IsEncrypted(ciphertext) || !found {
+		return "", errors.New("value is not encrypted")
+	}
+	return id, nil
+}
+
+// Encrypt seals plain with the primary key and a random nonce. The context,
+// like the column the value is stored in, is authenticated along with the key
+// id so the value only decrypts with the same context.
+func (e *Encryptor) Encrypt(plain []byte, context string) (string, error) {
+	id := e.Primary()
+	aead, err := e.key(id)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, additionalData(id, context))
+	return encryptPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// additionalData binds a ciphertext to its key and context, the key id cannot
+// contain a colon so neither can be confused with the other
+func additionalData(id string, context string) []byte {
+	return []byte(id + ":" + context)
+}
+
+// fieldContext is the context a field is encrypted with, its table tag and
+// column like voicemail.vm_pin or .secret for the main table. A value copied
+// to another column fails to decrypt. The main table and the row are not part
+// of it as neither is known when a row is read into a struct.
+func fieldContext(fld reflect.StructField) string {
+	col, _ := DatabaseColumns(fld)
+	return fld.Tag.Get("table") + "." + col
+}
+
+// Decrypt opens a value from Encrypt with the key named in it and the context
+// it was encrypted with
+func (e *Encryptor) Decrypt(ciphertext string, context string) ([]byte, error) {
+	id, err := KeyOf(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.key(id)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext[len(encryptPrefix)+len(id)+1:])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], additionalData(id, context))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt with key %s failed. %w", id, err)
+	}
+	return plain, nil
+}
+
+// Reencrypt decrypts a value and encrypts it again with the primary key and the
+// same context. The value is returned as is, with false, when it already uses
+// the primary key.
+func (e *Encryptor) Reencrypt(ciphertext string, context string) (string, bool, error) {
+	id, err := KeyOf(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	if id == e.Primary() {
+		return ciphertext, false, nil
+	}
+	plain, err := e.Decrypt(ciphertext, context)
+	if err != nil {
+		return "", false, err
+	}
+	out, err := e.Encrypt(plain, context)
+	return out, err == nil, err
+}
+
+// ReencryptValues re-encrypts the values of the encrypted columns of h, values
+// likely read straight from the database for a key rotation. Only the values
+// that changed are returned so they can be used to update the row. Values that
+// are not encrypted yet are encrypted.
+func (e *Encryptor) ReencryptValues(h any, values []Value) ([]Value, error) {
+	s := schemaOf(baseType(reflect.TypeOf(h)))
+	var changed []Value
+	for _, v := range values {
+		f, found := s.byColumn[v.Col]
+		if !found || !f.encrypt || v.Val == nil {
+			continue
+		}
+		text, isText := encryptedText(v.Val)
+		if !isText {
+			return nil, fmt.Errorf("column %s is not text", v.Col)
+		}
+		var out string
+		var err error
+		if IsEncrypted(text) {
+			var rotated bool
+			if out, rotated, err = e.Reencrypt(text, fieldContext(f.StructField)); err == nil && !rotated {
+				continue
+			}
+		} else {
+			out, err = e.Encrypt([]byte(text), fieldContext(f.StructField))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %s. %w", v.Col, err)
+		}
+		changed = append(changed, Value{Col: v.Col, Val: out})
+	}
+	return changed, nil
+}
+
+// encryptedText is the text of a value as database drivers return it
+func encryptedText(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case []byte:
+		return string(t), true
+	}
+	return "", false
+}
+
+// EncryptValues is a ValueReader for ReadValues that encrypts the values of
+// fields tagged `encrypt:"true"`, statements built here already do. Fields have to be strings or []byte, the ciphertext
+// is always a string.
+func (e *Encryptor) EncryptValues(v any, fld reflect.StructField) (any, error) {
+	if p := fld.Tag.Get("encrypt"); p == "" || p == "false" {
+		return v, nil
+	}
+	ref := reflect.ValueOf(v)
+	if ref.Kind() == reflect.Ptr {
+		if ref.IsNil() {
+			return nil, nil
+		}
+		ref = ref.Elem()
+	}
+	var plain []byte
+	switch {
+	case ref.Kind() == reflect.String:
+		plain = []byte(ref.String())
+	case isBytes(ref.Type()):
+		if ref.IsNil() {
+			return nil, nil
+		}
+		plain = ref.Bytes()
+	default:
+		return nil, fmt.Errorf("encrypted field %s must be a string or []byte", fld.Name)
+	}
+	return e.Encrypt(plain, fieldContext(fld))
+}
+
+// EncryptValues encrypts fields with the DefaultEncryptor
+func EncryptValues(v any, fld reflect.StructField) (any, error) {
+	return DefaultEncryptor.EncryptValues(v, fld)
+}
+
+// decryptValue decrypts a value read from the database for f with the
+// DefaultEncryptor
+func decryptValue(f *fieldSchema, v Value) (Value, error) {
+	val, err := DefaultEncryptor.decrypt(f, v.Val)
+	if err != nil {
+		return v, fmt.Errorf("SetValues: field %s from column %s. %w", f.Name, v.Col, err)
+	}
+	v.Val = val
+	return v, nil
+}
+
+// decrypt is the plain value of an encrypted field read from the database.
+// Values that are not encrypted, like rows written before the column was
+// encrypted, are returned as they are.
+func (e *Encryptor) decrypt(f *fieldSchema, v any) (any, error) {
+	if !f.encrypt {
+		return v, nil
+	}
+	text, isText := encryptedText(v)
+	if !isText || !IsEncrypted(text) {
+		return v, nil
+	}
+	plain, err := e.Decrypt(text, fieldContext(f.StructField))
+	if err != nil {
+		return nil, err
+	}
+	if isBytes(baseType(f.Type)) {
+		return plain, nil
+	}
+	return string(plain), nil
+}
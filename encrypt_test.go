@@ -0,0 +1,272 @@
+package ncservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type encryptTestStruct struct {
+	ID     int     `json:"id" gorm:"column:id;primaryKey"`
+	Name   string  `json:"name" gorm:"column:name"`
+	Secret string  `json:"secret" gorm:"column:secret" encrypt:"true"`
+	Token  *string `json:"token" gorm:"column:token" encrypt:"true"`
+	Blob   []byte  `json:"blob" gorm:"column:blob" encrypt:"true"`
+}
+
+func testEncryptor(t *testing.T, ids ...string) *Encryptor {
+	e := &Encryptor{}
+	for _, id := range ids {
+		require.NoError(t, e.AddKey(id, bytes.Repeat([]byte(id[:1]), 32)))
+	}
+	return e
+}
+
+// useEncryptor swaps the DefaultEncryptor for the duration of a test
+func useEncryptor(t *testing.T, e *Encryptor) {
+	prev := DefaultEncryptor
+	DefaultEncryptor = e
+	t.Cleanup(func() { DefaultEncryptor = prev })
+}
+
+func TestEncryptValues(t *testing.T) {
+	useEncryptor(t, testEncryptor(t, "k1"))
+	x := &encryptTestStruct{ID: 1, Name: "trunk", Secret: "sip-pass", Token: Ptr("tok"), Blob: []byte("bin")}
+	vals, err := ReadValues(x, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: EncryptValues})
+	require.NoError(t, err)
+	require.Len(t, vals, 5)
+	assert.Equal(t, "trunk", vals[1].Val)
+	for _, v := range vals[2:] {
+		s := v.Val.(string)
+		assert.True(t, strings.HasPrefix(s, "enc:k1:"), v.Col)
+		for _, plain := range []string{"sip-pass", "tok", "bin"} {
+			assert.NotContains(t, s, plain, v.Col)
+		}
+	}
+
+	var read encryptTestStruct
+	require.NoError(t, SetValues(&read, vals))
+	assert.Equal(t, *x, read)
+
+	// drivers return text as bytes
+	vals[2].Val = []byte(vals[2].Val.(string))
+	read = encryptTestStruct{}
+	require.NoError(t, SetValues(&read, vals))
+	assert.Equal(t, "sip-pass", read.Secret)
+
+	// rows written before the column was encrypted are read as they are
+	read = encryptTestStruct{}
+	require.NoError(t, SetValues(&read, []Value{{Col: "secret", Val: "plain"}}))
+	assert.Equal(t, "plain", read.Secret)
+
+	// the same value is different every time
+	again, err := ReadValues(x, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: EncryptValues})
+	require.NoError(t, err)
+	assert.NotEqual(t, vals[3].Val, again[3].Val)
+
+	x.Token = nil
+	x.Blob = nil
+	vals, err = ReadValues(x, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: EncryptValues})
+	require.NoError(t, err)
+	assert.Nil(t, vals[3].Val)
+	assert.Nil(t, vals[4].Val)
+}
+
+func TestEncryptValuesErrors(t *testing.T) {
+	useEncryptor(t, &Encryptor{})
+	_, err := ReadValues(&encryptTestStruct{}, ReadValueOptions{ColumnMapper: DatabaseColumns, Reader: EncryptValues})
+	assert.ErrorContains(t, err, "no encryption key")
+
+	type bad struct {
+		Count int `gorm:"column:cnt" encrypt:"true"`
+	}
+	useEncryptor(t, testEncryptor(t, "k1"))
+	_, err = ReadValues(&bad{}, ReadValueOptions{ColumnMapper: DatabaseColumns, Reader: EncryptValues})
+	assert.ErrorContains(t, err, "must be a string")
+
+	ciphertext, err := DefaultEncryptor.Encrypt([]byte("x"), ".secret")
+	require.NoError(t, err)
+	useEncryptor(t, testEncryptor(t, "k2"))
+	err = SetValues(&encryptTestStruct{}, []Value{{Col: "secret", Val: ciphertext}})
+	assert.ErrorContains(t, err, "unknown encryption key k1")
+
+	// a key with the same id but different bytes fails authentication
+	other := &Encryptor{}
+	require.NoError(t, other.AddKey("k1", bytes.Repeat([]byte("z"), 32)))
+	_, err = other.Decrypt(ciphertext, ".secret")
+	assert.ErrorContains(t, err, "decrypt with key k1 failed")
+
+	// a value moved to another column or table fails authentication
+	useEncryptor(t, testEncryptor(t, "k1"))
+	err = SetValues(&encryptTestStruct{}, []Value{{Col: "token", Val: ciphertext}})
+	assert.ErrorContains(t, err, "decrypt with key k1 failed")
+	_, err = DefaultEncryptor.Decrypt(ciphertext, "voicemail.secret")
+	assert.ErrorContains(t, err, "decrypt with key k1 failed")
+	plain, err := DefaultEncryptor.Decrypt(ciphertext, ".secret")
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(plain))
+
+	_, err = DefaultEncryptor.Decrypt("enc:k1:!!", ".secret")
+	assert.ErrorContains(t, err, "malformed")
+	_, err = DefaultEncryptor.Decrypt("plain", ".secret")
+	assert.Error(t, err)
+}
+
+func TestEncryptOnWrite(t *testing.T) {
+	useEncryptor(t, testEncryptor(t, "k1"))
+	x := &encryptTestStruct{ID: 1, Name: "trunk", Secret: "sip-pass", Token: Ptr("tok"), Blob: []byte("bin")}
+	stmt, err := MySQL.Insert("t", x, nil)
+	require.NoError(t, err)
+	require.Len(t, stmt.Args, 5)
+	row := []any{int64(1), []byte("trunk")}
+	for _, arg := range stmt.Args[2:] {
+		assert.True(t, strings.HasPrefix(arg.(string), "enc:k1:"))
+		row = append(row, []byte(arg.(string)))
+	}
+	rows := &fakeScanRows{cols: []string{"id", "name", "secret", "token", "blob"}, rows: [][]any{row}}
+	require.True(t, rows.Next())
+	var read encryptTestStruct
+	require.NoError(t, ScanRow(rows, &read, ScanOptions{Strict: true}))
+	assert.Equal(t, *x, read)
+
+	// every way of writing encrypts
+	encrypted := func(args []any) {
+		t.Helper()
+		n := 0
+		for _, arg := range args {
+			if s, isText := arg.(string); isText && IsEncrypted(s) {
+				n++
+			}
+			assert.NotEqual(t, "sip-pass", arg)
+		}
+		assert.NotZero(t, n)
+	}
+	stmt, err = MySQL.Update("t", x, nil)
+	require.NoError(t, err)
+	encrypted(stmt.Args)
+	stmt, err = SQLServer.Upsert("t", x, nil)
+	require.NoError(t, err)
+	encrypted(stmt.Args)
+	plan, err := PlanUpdate("t", x, nil)
+	require.NoError(t, err)
+	require.Len(t, plan, 1)
+	var vals []any
+	for _, v := range plan[0].Values {
+		vals = append(vals, v.Val)
+	}
+	encrypted(vals)
+	chunks, _, err := BulkInsert{Dialect: MySQL, Table: "t"}.Chunks([]*encryptTestStruct{x})
+	require.NoError(t, err)
+	encrypted(chunks[0].Args)
+
+	v := &struct {
+		encryptTestStruct
+		Version int `json:"lastModified" gorm:"column:version"`
+	}{encryptTestStruct: *x}
+	db := &affectingExecer{affected: 1}
+	require.NoError(t, UpdateIfUnmodified(context.Background(), db, MySQL, "t", v, nil))
+	encrypted(db.args)
+
+	// the struct itself is left as it is
+	assert.Equal(t, "sip-pass", x.Secret)
+}
+
+func TestEncryptorKeys(t *testing.T) {
+	e := &Encryptor{}
+	assert.Error(t, e.AddKey("", make([]byte, 32)))
+	assert.Error(t, e.AddKey("a:b", make([]byte, 32)))
+	assert.Error(t, e.AddKey("short", make([]byte, 10)))
+	assert.Error(t, e.SetPrimary("k1"))
+	for _, size := range []int{16, 24, 32} {
+		require.NoError(t, e.AddKey(fmt.Sprintf("k%d", size), make([]byte, size)))
+	}
+	assert.Equal(t, "k16", e.Primary())
+	require.NoError(t, e.SetPrimary("k32"))
+	ciphertext, err := e.Encrypt([]byte("x"), "")
+	require.NoError(t, err)
+	id, err := KeyOf(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "k32", id)
+}
+
+func TestReencrypt(t *testing.T) {
+	e := testEncryptor(t, "old")
+	old, err := e.Encrypt([]byte("sip-pass"), ".secret")
+	require.NoError(t, err)
+
+	require.NoError(t, e.AddKey("new", bytes.Repeat([]byte("n"), 32)))
+	require.NoError(t, e.SetPrimary("new"))
+	rotated, changed, err := e.Reencrypt(old, ".secret")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(rotated, "enc:new:"))
+	plain, err := e.Decrypt(rotated, ".secret")
+	require.NoError(t, err)
+	assert.Equal(t, "sip-pass", string(plain))
+
+	same, changed, err := e.Reencrypt(rotated, ".secret")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, rotated, same)
+
+	// a row as read from the database, only the stale columns are updated
+	current, err := e.Encrypt([]byte("tok"), ".token")
+	require.NoError(t, err)
+	vals, err := e.ReencryptValues(&encryptTestStruct{}, []Value{
+		{Col: "id", Val: 1},
+		{Col: "name", Val: "trunk"},
+		{Col: "secret", Val: []byte(old)},
+		{Col: "token", Val: current},
+		{Col: "blob", Val: "legacy plain"},
+	})
+	require.NoError(t, err)
+	require.Len(t, vals, 2)
+	assert.Equal(t, "secret", vals[0].Col)
+	assert.Equal(t, "blob", vals[1].Col)
+	useEncryptor(t, e)
+	var read encryptTestStruct
+	require.NoError(t, SetValues(&read, vals))
+	assert.Equal(t, "sip-pass", read.Secret)
+	assert.Equal(t, []byte("legacy plain"), read.Blob)
+}
+
+func TestDecryptOnRead(t *testing.T) {
+	e := testEncryptor(t, "k1")
+	useEncryptor(t, e)
+	secret, err := e.Encrypt([]byte("sip-pass"), ".secret")
+	require.NoError(t, err)
+	blob, err := e.Encrypt([]byte("bin"), ".blob")
+	require.NoError(t, err)
+
+	rows := &fakeScanRows{
+		cols: []string{"id", "secret", "blob"},
+		rows: [][]any{{int64(1), []byte(secret), []byte(blob)}},
+	}
+	all, err := ScanRows[encryptTestStruct](rows, ScanOptions{})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "sip-pass", all[0].Secret)
+	assert.Equal(t, []byte("bin"), all[0].Blob)
+
+	c := NewCodec[encryptTestStruct](CodecOptions{})
+	x := c.New()
+	require.NoError(t, c.Set(x, []Value{{Col: "secret", Val: secret}}))
+	assert.Equal(t, "sip-pass", x.Secret)
+
+	// JSON from a client is never decrypted
+	x = &encryptTestStruct{}
+	require.NoError(t, SetJsonValues(x, []Value{{Col: "secret", Val: secret}}))
+	assert.Equal(t, secret, x.Secret)
+}
+
+func TestEncryptedRedacted(t *testing.T) {
+	x := &encryptTestStruct{ID: 1, Secret: "sip-pass"}
+	vals, err := ReadValues(x, ReadValueOptions{ColumnMapper: ApiColumns, Prefixer: ApiPrefix, Reader: HidePasswords})
+	require.NoError(t, err)
+	assert.Regexp(t, `^\[redacted [0-9a-f]{16}\]$`, vals[2].Val)
+}
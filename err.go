@@ -1,5 +1,7 @@
 package ncservice
 
+import "fmt"
+
 type Err struct {
 	Code int
 	Msg  string
@@ -9,6 +11,19 @@ func (e Err) Error() string {
 	return e.Msg
 }
 
+// Is matches errors with the same code so errors.Is(err, ErrUser) is true for
+// any user error regardless of the message
+func (e Err) Is(target error) bool {
+	t, valid := target.(Err)
+	return valid && t.Code == e.Code
+}
+
 var ErrNotFound = Err{404, "not found"}
 var ErrPermissionDenied = Err{403, "permission denied"}
 var ErrUser = Err{400, "user error"}
+var ErrConflict = Err{409, "conflict"}
+
+// ErrUserf is an ErrUser with a message specific to what the user did wrong
+func ErrUserf(format string, args ...any) Err {
+	return Err{ErrUser.Code, fmt.Sprintf(format, args...)}
+}
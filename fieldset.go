@@ -0,0 +1,125 @@
+package ncservice
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Fieldset is the subset of fields a client asked for with a parameter like
+// ?fields=name,email,address.city. Primary keys are always part of it.
+type Fieldset struct {
+	fields []*fieldSchema
+}
+
+// ParseFields reads a comma separated list of JSON field names of T. Names are
+// case insensitive and a nested struct like address selects all of its fields.
+// Unknown fields and password fields are user errors. An empty list selects
+// every field.
+func ParseFields[T any](param string) (Fieldset, error) {
+	s := schemaOf(reflect.TypeFor[T]())
+	var fs Fieldset
+	add := func(f *fieldSchema) {
+		if !slices.Contains(fs.fields, f) {
+			fs.fields = append(fs.fields, f)
+		}
+	}
+	if strings.TrimSpace(param) == "" {
+		for _, f := range s.fields {
+			if f.hasJson && !f.password {
+				add(f)
+			}
+		}
+		return fs, nil
+	}
+	for _, f := range s.keys {
+		add(f)
+	}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var selected []*fieldSchema
+		if f, found := s.byJson[strings.ToLower(name)]; found {
+			selected = []*fieldSchema{f}
+		} else {
+			selected = s.nestedFieldsFold(name)
+		}
+		if len(selected) == 0 {
+			return Fieldset{}, ErrUserf("unknown field %s", name)
+		}
+		for _, f := range selected {
+			if f.password {
+				return Fieldset{}, ErrUserf("field %s cannot be read", name)
+			}
+			add(f)
+		}
+	}
+	return fs, nil
+}
+
+// nestedFieldsFold is nestedFields ignoring case
+func (s *schema) nestedFieldsFold(path string) []*fieldSchema {
+	prefix := strings.ToLower(path) + "."
+	var nested []*fieldSchema
+	for _, f := range s.fields {
+		if f.hasJson && strings.HasPrefix(strings.ToLower(f.json), prefix) {
+			nested = append(nested, f)
+		}
+	}
+	return nested
+}
+
+// Fields are the JSON names of the selected fields in the order they are declared
+func (fs Fieldset) Fields() []string {
+	var names []string
+	for _, f := range fs.ordered() {
+		names = append(names, f.json)
+	}
+	return names
+}
+
+// Columns are the database columns of the selected fields that have one. Columns
+// of fields tagged for another table are qualified like voicemail.vm_pin.
+func (fs Fieldset) Columns() []string {
+	var cols []string
+	for _, f := range fs.ordered() {
+		if !f.hasColumn {
+			continue
+		}
+		if f.table != "" {
+			cols = append(cols, f.table+"."+f.column)
+		} else {
+			cols = append(cols, f.column)
+		}
+	}
+	return cols
+}
+
+func (fs Fieldset) ordered() []*fieldSchema {
+	ordered := slices.Clone(fs.fields)
+	slices.SortStableFunc(ordered, func(a, b *fieldSchema) int { return a.pos - b.pos })
+	return ordered
+}
+
+// Filter passes the selected fields of values read with ApiValues
+func (fs Fieldset) Filter() ValueFilter {
+	names := fs.Fields()
+	return func(p Value, fld reflect.StructField) bool {
+		return slices.Contains(names, p.Col)
+	}
+}
+
+// DatabaseFilter passes the selected fields of values read with Values
+func (fs Fieldset) DatabaseFilter() ValueFilter {
+	var cols []Value
+	for _, f := range fs.fields {
+		if f.hasColumn {
+			cols = append(cols, Value{Table: f.table, Col: f.column})
+		}
+	}
+	return func(p Value, fld reflect.StructField) bool {
+		return slices.ContainsFunc(cols, func(c Value) bool { return c.Table == p.Table && c.Col == p.Col })
+	}
+}
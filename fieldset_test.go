@@ -0,0 +1,49 @@
+package ncservice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fieldsetTestStruct struct {
+	Id       int              `json:"id" gorm:"column:id;primaryKey"`
+	Name     string           `json:"name" gorm:"column:name"`
+	Email    string           `json:"email" gorm:"column:email"`
+	Password string           `json:"password" gorm:"column:pass" password:"true"`
+	Pin      string           `json:"pin" gorm:"column:vm_pin" table:"voicemail"`
+	Address  patchTestAddress `json:"address" gorm:"embedded;embeddedPrefix:addr_"`
+}
+
+func TestParseFields(t *testing.T) {
+	fs, err := ParseFields[fieldsetTestStruct]("email, Address.City,pin")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "email", "pin", "address.city"}, fs.Fields())
+	assert.Equal(t, []string{"id", "email", "voicemail.vm_pin", "addr_city"}, fs.Columns())
+
+	x := &fieldsetTestStruct{Id: 1, Name: "joe", Email: "j@x", Pin: "12", Address: patchTestAddress{City: "c", Zip: Ptr("z")}}
+	vals, err := ApiValues(x, fs.Filter())
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}, {Col: "email", Val: "j@x"}, {Table: "voicemail", Col: "pin", Val: "12"},
+		{Col: "address.city", Val: "c"}}, vals)
+	vals, err = Values(x, fs.DatabaseFilter())
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}, {Col: "email", Val: "j@x"}, {Table: "voicemail", Col: "vm_pin", Val: "12"},
+		{Col: "addr_city", Val: "c"}}, vals)
+
+	fs, err = ParseFields[fieldsetTestStruct]("address")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "address.city", "address.zip"}, fs.Fields())
+
+	fs, err = ParseFields[fieldsetTestStruct]("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "name", "email", "pin", "address.city", "address.zip"}, fs.Fields())
+
+	_, err = ParseFields[fieldsetTestStruct]("name,bogus")
+	assert.True(t, errors.Is(err, ErrUser))
+	assert.Equal(t, "unknown field bogus", err.Error())
+	_, err = ParseFields[fieldsetTestStruct]("password")
+	assert.True(t, errors.Is(err, ErrUser))
+}
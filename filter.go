@@ -1,8 +1,6 @@
 package ncservice
 
 import (
-	"fmt"
-	"hash/crc32"
 	"reflect"
 	"slices"
 )
@@ -49,37 +47,33 @@ func FilterNil(param Value, fld reflect.StructField) bool {
 	return false
 }
 
-// FilterNotEqual is like a diff useful to tell the difference of an object for before and after
+// DiffVals is like a diff useful to tell the difference of an object for before
+// and after. Values are matched by column. See Diff for nested changes.
 func DiffVals(origVals []Value, updatedVals []Value) []DiffVal {
 	var diff []DiffVal
-
-outer1:
+	updatedByCol := make(map[string]int, len(updatedVals))
+	for i, updated := range updatedVals {
+		if _, dup := updatedByCol[updated.Col]; !dup {
+			updatedByCol[updated.Col] = i
+		}
+	}
+	origCols := make(map[string]bool, len(origVals))
 	for _, orig := range origVals {
+		origCols[orig.Col] = true
 		found := DiffVal{Col: orig.Col, Orig: orig.Val}
-		for _, updated := range updatedVals {
-			if orig.Col == updated.Col {
-				if reflect.DeepEqual(orig.Val, updated.Val) {
-					continue outer1
-				} else {
-					found.Updated = updated.Val
-					break
-				}
+		if i, exists := updatedByCol[orig.Col]; exists {
+			if reflect.DeepEqual(orig.Val, updatedVals[i].Val) {
+				continue
 			}
+			found.Updated = updatedVals[i].Val
 		}
 		diff = append(diff, found)
 	}
-
-outer2:
 	for _, updated := range updatedVals {
-		found := DiffVal{Col: updated.Col, Updated: updated.Val}
-		for _, orig := range origVals {
-			if orig.Col == updated.Col {
-				continue outer2
-			}
+		if !origCols[updated.Col] {
+			diff = append(diff, DiffVal{Col: updated.Col, Updated: updated.Val})
 		}
-		diff = append(diff, found)
 	}
-
 	return diff
 }
 
@@ -100,6 +94,17 @@ func FilterAnd(f ...ValueFilter) ValueFilter {
 	}
 }
 
+func FilterOr(f ...ValueFilter) ValueFilter {
+	return func(p Value, fld reflect.StructField) bool {
+		for _, f1 := range f {
+			if f1(p, fld) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func FilterOnlyKeys(x any) ValueFilter {
 	return filterKeys(x, true)
 }
@@ -109,25 +114,9 @@ func FilterNoKeys(x any) ValueFilter {
 }
 
 func filterKeys(x any, isKey bool) ValueFilter {
-	ref := reflect.ValueOf(x)
-	if ref.Kind() == reflect.Ptr {
-		ref = ref.Elem()
-	}
-	t := ref.Type()
-	n := ref.NumField()
 	var keys []string
-	for i := range n {
-		fld := t.Field(i)
-		tag := fld.Tag.Get("gorm")
-		_, exists := getGormTag(tag, "primaryKey")
-		if !exists {
-			continue
-		}
-		col, exists := getGormTag(tag, "column")
-		if !exists {
-			continue
-		}
-		keys = append(keys, col)
+	for _, f := range schemaOf(reflect.TypeOf(x)).keys {
+		keys = append(keys, f.column)
 	}
 	return func(p Value, fld reflect.StructField) bool {
 		if slices.Contains(keys, p.Col) {
@@ -137,23 +126,10 @@ func filterKeys(x any, isKey bool) ValueFilter {
 	}
 }
 
-func appendFiltered(args []Value, p Value, fld reflect.StructField, f ValueFilter) []Value {
-	if f == nil || f(p, fld) {
-		return append(args, p)
-	}
-	return args
-}
-
-var passwordScrambler = crc32.MakeTable(0xfafa983d) // any quasi-random val
-
+// HidePasswords is a ValueReader that hides sensitive values using the
+// DefaultRedactor. Password fields become a fingerprint like [redacted 1f2e..]
+// that is different for different values should caller want to know if a
+// password has changed.
 func HidePasswords(v any, fld reflect.StructField) (any, error) {
-	if fld.Tag.Get("password") != "" {
-		// here we hash the password to a useless, but predictable value that will hide
-		// what the password is, but return a value that will be different on different
-		// values should caller want to know if a password has changed
-		orig := fmt.Sprintf("%v", v)
-		scrambled := crc32.Checksum([]byte(orig), passwordScrambler)
-		return fmt.Sprintf("[redacted %v]", scrambled), nil
-	}
-	return v, nil
+	return DefaultRedactor.Redact(v, fld)
 }
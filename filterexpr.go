@@ -0,0 +1,267 @@
+package ncservice
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Operators of a FilterExpr
+const (
+	FilterOpAll     = "all"
+	FilterOpNone    = "none"
+	FilterOpAnd     = "and"
+	FilterOpOr      = "or"
+	FilterOpNot     = "not"
+	FilterOpInclude = "include"
+	FilterOpExclude = "exclude"
+	FilterOpKeys    = "keys"
+	FilterOpNil     = "nil"
+	FilterOpTag     = "tag"
+)
+
+// FilterExpr is a ValueFilter that can be inspected, printed and stored as JSON
+// so policies on which fields to read can live in config. For example
+//
+//	{"op":"and","args":[{"op":"exclude","cols":["pass"]},{"op":"not","args":[{"op":"nil"}]}]}
+//
+// and, or and not combine args. include and exclude match columns. keys matches
+// primary keys, nil matches values FilterNil does and tag matches fields with a
+// struct tag, optionally with a given value.
+type FilterExpr struct {
+	Op    string       `json:"op"`
+	Args  []FilterExpr `json:"args,omitempty"`
+	Cols  []string     `json:"cols,omitempty"`
+	Tag   string       `json:"tag,omitempty"`
+	Value string       `json:"value,omitempty"`
+}
+
+func AllExpr() FilterExpr {
+	return FilterExpr{Op: FilterOpAll}
+}
+
+func AndExpr(args ...FilterExpr) FilterExpr {
+	return FilterExpr{Op: FilterOpAnd, Args: args}
+}
+
+func OrExpr(args ...FilterExpr) FilterExpr {
+	return FilterExpr{Op: FilterOpOr, Args: args}
+}
+
+func NotExpr(arg FilterExpr) FilterExpr {
+	return FilterExpr{Op: FilterOpNot, Args: []FilterExpr{arg}}
+}
+
+func IncludeExpr(cols ...string) FilterExpr {
+	return FilterExpr{Op: FilterOpInclude, Cols: cols}
+}
+
+func ExcludeExpr(cols ...string) FilterExpr {
+	return FilterExpr{Op: FilterOpExclude, Cols: cols}
+}
+
+func KeysExpr() FilterExpr {
+	return FilterExpr{Op: FilterOpKeys}
+}
+
+func NilExpr() FilterExpr {
+	return FilterExpr{Op: FilterOpNil}
+}
+
+// TagExpr matches fields with the tag, when value is empty any value matches
+func TagExpr(tag string, value string) FilterExpr {
+	return FilterExpr{Op: FilterOpTag, Tag: tag, Value: value}
+}
+
+// Compile turns the expression into a ValueFilter. Unknown operators or missing
+// arguments are errors.
+func (e FilterExpr) Compile() (ValueFilter, error) {
+	switch e.Op {
+	case FilterOpAll:
+		return FilterAll, nil
+	case FilterOpNone:
+		return FilterNot(FilterAll), nil
+	case FilterOpAnd, FilterOpOr:
+		args := make([]ValueFilter, len(e.Args))
+		for i, arg := range e.Args {
+			f, err := arg.Compile()
+			if err != nil {
+				return nil, err
+			}
+			args[i] = f
+		}
+		if e.Op == FilterOpAnd {
+			return FilterAnd(args...), nil
+		}
+		return FilterOr(args...), nil
+	case FilterOpNot:
+		if len(e.Args) != 1 {
+			return nil, fmt.Errorf("filter not needs 1 argument, not %d", len(e.Args))
+		}
+		f, err := e.Args[0].Compile()
+		if err != nil {
+			return nil, err
+		}
+		return FilterNot(f), nil
+	case FilterOpInclude:
+		return FilterInclude(e.Cols), nil
+	case FilterOpExclude:
+		return FilterExclude(e.Cols), nil
+	case FilterOpKeys:
+		return func(_ Value, fld reflect.StructField) bool {
+			_, isKey := getGormTag(fld.Tag.Get("gorm"), "primaryKey")
+			return isKey
+		}, nil
+	case FilterOpNil:
+		return FilterNil, nil
+	case FilterOpTag:
+		if e.Tag == "" {
+			return nil, errors.New("filter tag needs a tag name")
+		}
+		tag, value := e.Tag, e.Value
+		return func(_ Value, fld reflect.StructField) bool {
+			v, exists := fld.Tag.Lookup(tag)
+			return exists && (value == "" || v == value)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown filter operator '%s'", e.Op)
+}
+
+// String prints the expression like and(exclude(pass), not(nil))
+func (e FilterExpr) String() string {
+	switch e.Op {
+	case FilterOpAnd, FilterOpOr, FilterOpNot:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = arg.String()
+		}
+		return e.Op + "(" + strings.Join(args, ", ") + ")"
+	case FilterOpInclude, FilterOpExclude:
+		return e.Op + "(" + strings.Join(e.Cols, ", ") + ")"
+	case FilterOpTag:
+		if e.Value != "" {
+			return e.Op + "(" + e.Tag + "=" + e.Value + ")"
+		}
+		return e.Op + "(" + e.Tag + ")"
+	}
+	return e.Op
+}
+
+// Simplify returns an equivalent expression with nested and/or flattened,
+// double negation removed, constants folded, duplicates dropped and the columns
+// of sibling include and exclude merged.
+func (e FilterExpr) Simplify() FilterExpr {
+	switch e.Op {
+	case FilterOpNot:
+		if len(e.Args) != 1 {
+			return e
+		}
+		arg := e.Args[0].Simplify()
+		switch arg.Op {
+		case FilterOpNot:
+			if len(arg.Args) == 1 {
+				return arg.Args[0]
+			}
+		case FilterOpAll:
+			return FilterExpr{Op: FilterOpNone}
+		case FilterOpNone:
+			return AllExpr()
+		}
+		return NotExpr(arg)
+	case FilterOpAnd, FilterOpOr:
+		return e.simplifyLogic()
+	case FilterOpInclude, FilterOpExclude:
+		cols := slices.Clone(e.Cols)
+		slices.Sort(cols)
+		cols = slices.Compact(cols)
+		if len(cols) == 0 {
+			// nothing is included or nothing is excluded
+			if e.Op == FilterOpInclude {
+				return FilterExpr{Op: FilterOpNone}
+			}
+			return AllExpr()
+		}
+		return FilterExpr{Op: e.Op, Cols: cols}
+	}
+	return e
+}
+
+func (e FilterExpr) simplifyLogic() FilterExpr {
+	// in an and, all is the identity and none absorbs everything, the opposite in an or
+	identity, absorbing := FilterOpAll, FilterOpNone
+	if e.Op == FilterOpOr {
+		identity, absorbing = FilterOpNone, FilterOpAll
+	}
+	var args []FilterExpr
+	include, exclude := -1, -1
+	var add func(arg FilterExpr) bool
+	add = func(arg FilterExpr) bool {
+		switch {
+		case arg.Op == identity:
+			return true
+		case arg.Op == absorbing:
+			return false
+		case arg.Op == e.Op:
+			for _, nested := range arg.Args {
+				if !add(nested) {
+					return false
+				}
+			}
+			return true
+		case arg.Op == FilterOpInclude && include >= 0:
+			args[include].Cols = mergeCols(args[include].Cols, arg.Cols, e.Op == FilterOpOr)
+			return true
+		case arg.Op == FilterOpExclude && exclude >= 0:
+			args[exclude].Cols = mergeCols(args[exclude].Cols, arg.Cols, e.Op == FilterOpAnd)
+			return true
+		}
+		for _, existing := range args {
+			if reflect.DeepEqual(existing, arg) {
+				return true
+			}
+		}
+		args = append(args, arg)
+		switch arg.Op {
+		case FilterOpInclude:
+			include = len(args) - 1
+		case FilterOpExclude:
+			exclude = len(args) - 1
+		}
+		return true
+	}
+	for _, arg := range e.Args {
+		if !add(arg.Simplify()) {
+			return FilterExpr{Op: absorbing}
+		}
+	}
+	// merged columns may have emptied an include or exclude
+	for i := range args {
+		args[i] = args[i].Simplify()
+	}
+	switch len(args) {
+	case 0:
+		return FilterExpr{Op: identity}
+	case 1:
+		return args[0]
+	}
+	if !slices.ContainsFunc(args, func(a FilterExpr) bool { return a.Op == absorbing }) {
+		return FilterExpr{Op: e.Op, Args: args}
+	}
+	return FilterExpr{Op: absorbing}
+}
+
+// mergeCols is the union or the intersection of two column lists
+func mergeCols(a []string, b []string, union bool) []string {
+	if union {
+		return append(slices.Clone(a), b...)
+	}
+	var both []string
+	for _, col := range a {
+		if slices.Contains(b, col) {
+			both = append(both, col)
+		}
+	}
+	return both
+}
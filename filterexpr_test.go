@@ -0,0 +1,93 @@
+package ncservice
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterOr(t *testing.T) {
+	x := sqlTestStruct{ID: 1, Name: "joe"}
+	vals, err := Values(&x, FilterOr(FilterInclude([]string{"name"}), FilterOnlyKeys(x)))
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "id", Val: 1}, {Col: "name", Val: "joe"}}, vals)
+}
+
+func TestFilterExpr(t *testing.T) {
+	x := struct {
+		ID    int     `gorm:"column:id;primaryKey"`
+		Name  string  `gorm:"column:name" show:"full"`
+		Email *string `gorm:"column:email"`
+		Pass  string  `gorm:"column:pass" password:"true"`
+	}{ID: 1, Name: "joe", Pass: "x"}
+	cols := func(e FilterExpr) []string {
+		f, err := e.Compile()
+		require.NoError(t, err)
+		vals, err := Values(&x, f)
+		require.NoError(t, err)
+		var cols []string
+		for _, v := range vals {
+			cols = append(cols, v.Col)
+		}
+		return cols
+	}
+	assert.Equal(t, []string{"id", "name"}, cols(AndExpr(NotExpr(NilExpr()), NotExpr(TagExpr("password", "")))))
+	assert.Equal(t, []string{"id", "name"}, cols(OrExpr(KeysExpr(), TagExpr("show", "full"))))
+	assert.Equal(t, []string{"email"}, cols(AndExpr(ExcludeExpr("pass"), NilExpr())))
+	assert.Equal(t, []string{"name", "email"}, cols(AndExpr(IncludeExpr("name", "email", "pass"), NotExpr(TagExpr("password", "true")))))
+	assert.Nil(t, cols(FilterExpr{Op: FilterOpNone}))
+
+	_, err := FilterExpr{Op: "bogus"}.Compile()
+	assert.EqualError(t, err, "unknown filter operator 'bogus'")
+	_, err = AndExpr(FilterExpr{Op: FilterOpNot}).Compile()
+	assert.Error(t, err)
+}
+
+func TestFilterExprJson(t *testing.T) {
+	e := AndExpr(ExcludeExpr("pass"), NotExpr(NilExpr()), TagExpr("show", "full"))
+	assert.Equal(t, "and(exclude(pass), not(nil), tag(show=full))", e.String())
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	assert.Equal(t, `{"op":"and","args":[{"op":"exclude","cols":["pass"]},{"op":"not","args":[{"op":"nil"}]},`+
+		`{"op":"tag","tag":"show","value":"full"}]}`, string(data))
+	var back FilterExpr
+	require.NoError(t, json.Unmarshal(data, &back))
+	assert.Equal(t, e, back)
+}
+
+func TestFilterExprSimplify(t *testing.T) {
+	tests := []struct {
+		in       FilterExpr
+		expected string
+	}{
+		{NotExpr(NotExpr(NilExpr())), "nil"},
+		{AndExpr(AllExpr(), AndExpr(NilExpr(), KeysExpr()), NilExpr()), "and(nil, keys)"},
+		{AndExpr(KeysExpr(), NotExpr(AllExpr())), "none"},
+		{OrExpr(KeysExpr(), AllExpr()), "all"},
+		{OrExpr(IncludeExpr("b", "a"), IncludeExpr("c", "a")), "include(a, b, c)"},
+		{AndExpr(IncludeExpr("b", "a"), NilExpr(), IncludeExpr("c", "a")), "and(include(a), nil)"},
+		{AndExpr(IncludeExpr("a"), IncludeExpr("b")), "none"},
+		{AndExpr(ExcludeExpr("a"), ExcludeExpr("b")), "exclude(a, b)"},
+		{OrExpr(), "none"},
+		{AndExpr(), "all"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.in.Simplify().String(), test.in.String())
+	}
+}
+
+func TestFilterExprSimplifyMalformed(t *testing.T) {
+	for _, config := range []string{
+		`{"op":"not","args":[{"op":"not"}]}`,
+		`{"op":"not","args":[{"op":"not","args":[{"op":"nil"},{"op":"keys"}]}]}`,
+		`{"op":"and","args":[{"op":"not","args":[{"op":"not"}]}]}`,
+	} {
+		var e FilterExpr
+		require.NoError(t, json.Unmarshal([]byte(config), &e))
+		simple := e.Simplify()
+		_, err := simple.Compile()
+		assert.Error(t, err, config)
+	}
+}
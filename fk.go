@@ -0,0 +1,411 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ForeignKey is a column that references a column in another table, read from
+// an `fk:"table.col"` tag
+type ForeignKey struct {
+	Table     string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+func (k ForeignKey) String() string {
+	return fmt.Sprintf("%s.%s -> %s.%s", k.Table, k.Column, k.RefTable, k.RefColumn)
+}
+
+// RowChecker tells if any row in a table matches all the given column values
+type RowChecker interface {
+	Exists(ctx context.Context, table string, where []Value) (bool, error)
+}
+
+// Queryer is satisfied by *sql.DB, *sql.Tx, *sqlx.DB and *sqlx.Tx
+type Queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SqlRowChecker checks rows exist with a SELECT against a database
+type SqlRowChecker struct {
+	DB      Queryer
+	Dialect Dialect
+}
+
+func (c SqlRowChecker) Exists(ctx context.Context, table string, where []Value) (bool, error) {
+	stmt, err := c.Dialect.Exists(table, where)
+	if err != nil {
+		return false, err
+	}
+	var found int
+	err = c.DB.QueryRowContext(ctx, stmt.SQL, stmt.Args...).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Exists builds a query returning a single row with 1 when a row matches all
+// the where values and no rows otherwise
+func (d Dialect) Exists(table string, where []Value) (Statement, error) {
+	if len(where) == 0 {
+		return Statement{}, errNoKeys
+	}
+	b := &sqlBuilder{d: d}
+	if d == SQLServer {
+		b.write("SELECT TOP 1 1 FROM ", d.Quote(table))
+		b.where(where)
+	} else {
+		b.write("SELECT 1 FROM ", d.Quote(table))
+		b.where(where)
+		b.write(" LIMIT 1")
+	}
+	return b.statement(), nil
+}
+
+// ForeignKeyGraph collects the foreign keys of registered structs to check
+// references before writing and to order writes across tables. It is safe to
+// use from multiple goroutines.
+type ForeignKeyGraph struct {
+	mu   sync.RWMutex
+	keys []ForeignKey
+	// types are the structs registered for each table
+	types map[string][]reflect.Type
+}
+
+// DefaultForeignKeys is a graph services can share by registering their structs
+// at startup
+var DefaultForeignKeys = &ForeignKeyGraph{}
+
+// Register adds the foreign keys of h which is a struct or a pointer to a struct.
+// table is the table of all the fields that have no `table` tag. Registering
+// the same struct again has no effect.
+func (g *ForeignKeyGraph) Register(table string, h any) error {
+	t := reflect.TypeOf(h)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot register %s, not a struct", t)
+	}
+	keys, err := foreignKeysOf(t, table)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.types == nil {
+		g.types = make(map[string][]reflect.Type)
+	}
+	if !slices.Contains(g.types[table], t) {
+		g.types[table] = append(g.types[table], t)
+	}
+	for _, k := range keys {
+		if !slices.Contains(g.keys, k) {
+			g.keys = append(g.keys, k)
+		}
+	}
+	return nil
+}
+
+// foreignKeysOf reads the fk tags of a struct, table is the table of the fields
+// that have no `table` tag
+func foreignKeysOf(t reflect.Type, table string) ([]ForeignKey, error) {
+	var keys []ForeignKey
+	for _, f := range schemaOf(t).fields {
+		if f.fk == "" {
+			continue
+		}
+		refTable, refCol, valid := splitForeignKey(f.fk)
+		if !valid {
+			return nil, fmt.Errorf("invalid fk tag '%s' on %s.%s", f.fk, t.Name(), f.Name)
+		}
+		keys = append(keys, ForeignKey{
+			Table:     fieldTable(f, table),
+			Column:    f.column,
+			RefTable:  refTable,
+			RefColumn: refCol,
+		})
+	}
+	return keys, nil
+}
+
+func fieldTable(f *fieldSchema, table string) string {
+	if f.table != "" {
+		return f.table
+	}
+	return table
+}
+
+// References are the foreign keys in table
+func (g *ForeignKeyGraph) References(table string) []ForeignKey {
+	return g.find(func(k ForeignKey) bool { return k.Table == table })
+}
+
+// ReferencedBy are the foreign keys in other tables that point to table
+func (g *ForeignKeyGraph) ReferencedBy(table string) []ForeignKey {
+	return g.find(func(k ForeignKey) bool { return k.RefTable == table })
+}
+
+func (g *ForeignKeyGraph) find(match func(ForeignKey) bool) []ForeignKey {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var found []ForeignKey
+	for _, k := range g.keys {
+		if match(k) {
+			found = append(found, k)
+		}
+	}
+	return found
+}
+
+// Tables lists every table that is registered or part of a foreign key, sorted
+func (g *ForeignKeyGraph) Tables() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var tables []string
+	add := func(t string) {
+		if !slices.Contains(tables, t) {
+			tables = append(tables, t)
+		}
+	}
+	for t := range g.types {
+		add(t)
+	}
+	for _, k := range g.keys {
+		add(k.Table)
+		add(k.RefTable)
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+// CheckReferences verifies every foreign key value of h points to an existing
+// row before h is inserted or updated. table is the table of all the fields that
+// have no `table` tag. The foreign keys are those registered for each table h
+// writes to, so structs without fk tags of their own are checked too, along
+// with the fk tags of h. Unset references, nil or zero, are not checked. A
+// missing row is a user error.
+func (g *ForeignKeyGraph) CheckReferences(ctx context.Context, rows RowChecker, table string, h any) error {
+	ref := reflect.Indirect(reflect.ValueOf(h))
+	s := schemaOf(ref.Type())
+	keys, err := foreignKeysOf(ref.Type(), table)
+	if err != nil {
+		return err
+	}
+	tables := []string{table}
+	for _, f := range s.fields {
+		tables = append(tables, fieldTable(f, table))
+	}
+	for _, t := range uniqueTables(tables) {
+		for _, k := range g.References(t) {
+			if !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		i := slices.IndexFunc(s.fields, func(f *fieldSchema) bool {
+			return f.hasColumn && f.column == k.Column && fieldTable(f, table) == k.Table
+		})
+		if i < 0 {
+			continue
+		}
+		v, valid := fieldByIndex(ref, s.fields[i].index)
+		if !valid || v.IsZero() {
+			continue
+		}
+		val := reflect.Indirect(v).Interface()
+		found, err := rows.Exists(ctx, k.RefTable, []Value{{Table: k.RefTable, Col: k.RefColumn, Val: val}})
+		if err != nil {
+			return fmt.Errorf("checking %s. %w", k, err)
+		}
+		if !found {
+			return ErrUserf("%s %v does not exist", k.RefTable, val)
+		}
+	}
+	return nil
+}
+
+// CheckReferenced verifies no registered table has rows referencing h before h
+// is deleted from table. Referencing rows are a user error.
+func (g *ForeignKeyGraph) CheckReferenced(ctx context.Context, rows RowChecker, table string, h any) error {
+	ref := reflect.Indirect(reflect.ValueOf(h))
+	s := schemaOf(ref.Type())
+	for _, k := range g.ReferencedBy(table) {
+		if k.Table == table {
+			// rows referencing other rows in the same table like a parent are
+			// left for the database to decide
+			continue
+		}
+		f := s.fieldOfTableColumn(table, k.RefColumn)
+		if f == nil {
+			return fmt.Errorf("%s references %s.%s which is not a field of %s", k.Table, table, k.RefColumn, ref.Type())
+		}
+		v, valid := fieldByIndex(ref, f.index)
+		if !valid || v.IsZero() {
+			continue
+		}
+		val := reflect.Indirect(v).Interface()
+		found, err := rows.Exists(ctx, k.Table, []Value{{Table: k.Table, Col: k.Column, Val: val}})
+		if err != nil {
+			return fmt.Errorf("checking %s. %w", k, err)
+		}
+		if found {
+			return ErrUserf("%s %v is still used by %s", table, val, k.Table)
+		}
+	}
+	return nil
+}
+
+// fieldOfTableColumn finds the field of a column in table which is the table of
+// fields without a `table` tag
+func (s *schema) fieldOfTableColumn(table string, col string) *fieldSchema {
+	for _, f := range s.fields {
+		if f.hasColumn && f.column == col && (f.table == "" || f.table == table) {
+			return f
+		}
+	}
+	return nil
+}
+
+// CycleErr is returned when tables reference each other so no order of writes
+// satisfies every foreign key
+type CycleErr struct {
+	Tables []string
+}
+
+func (e CycleErr) Error() string {
+	return "foreign key cycle " + strings.Join(e.Tables, " -> ")
+}
+
+// InsertOrder sorts tables so referenced tables come before the tables that
+// reference them. With no tables given, every table in the graph is sorted.
+// Tables that reference themselves are allowed, other cycles are a CycleErr.
+func (g *ForeignKeyGraph) InsertOrder(tables ...string) ([]string, error) {
+	if len(tables) == 0 {
+		tables = g.Tables()
+	}
+	tables = uniqueTables(tables)
+	deps := g.dependencies(tables)
+	if cycle := findCycle(tables, deps); cycle != nil {
+		return nil, CycleErr{Tables: cycle}
+	}
+	// repeatedly take the first table, in the order given, whose dependencies
+	// are all done so the result is stable
+	ordered := make([]string, 0, len(tables))
+	done := make(map[string]bool)
+	for len(ordered) < len(tables) {
+		for _, t := range tables {
+			if done[t] {
+				continue
+			}
+			if !slices.ContainsFunc(deps[t], func(d string) bool { return !done[d] }) {
+				done[t] = true
+				ordered = append(ordered, t)
+				break
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// uniqueTables drops repeated tables keeping the first
+func uniqueTables(tables []string) []string {
+	seen := make(map[string]bool, len(tables))
+	unique := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// DeleteOrder sorts tables so tables referencing others come first
+func (g *ForeignKeyGraph) DeleteOrder(tables ...string) ([]string, error) {
+	ordered, err := g.InsertOrder(tables...)
+	slices.Reverse(ordered)
+	return ordered, err
+}
+
+// Cycles lists each group of tables that reference each other, not counting
+// tables that only reference themselves
+func (g *ForeignKeyGraph) Cycles() [][]string {
+	tables := g.Tables()
+	deps := g.dependencies(tables)
+	var cycles [][]string
+	for {
+		cycle := findCycle(tables, deps)
+		if cycle == nil {
+			return cycles
+		}
+		cycles = append(cycles, cycle)
+		// break the cycle to look for others
+		from, to := cycle[len(cycle)-2], cycle[len(cycle)-1]
+		deps[from] = slices.DeleteFunc(slices.Clone(deps[from]), func(d string) bool { return d == to })
+	}
+}
+
+// dependencies maps each table to the tables it references, limited to tables
+func (g *ForeignKeyGraph) dependencies(tables []string) map[string][]string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	deps := make(map[string][]string)
+	for _, k := range g.keys {
+		if k.Table == k.RefTable || !slices.Contains(tables, k.Table) || !slices.Contains(tables, k.RefTable) {
+			continue
+		}
+		if !slices.Contains(deps[k.Table], k.RefTable) {
+			deps[k.Table] = append(deps[k.Table], k.RefTable)
+		}
+	}
+	return deps
+}
+
+// findCycle returns a path like a -> b -> a or nil when there are no cycles
+func findCycle(tables []string, deps map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(t string) []string
+	visit = func(t string) []string {
+		state[t] = visiting
+		path = append(path, t)
+		for _, d := range deps[t] {
+			switch state[d] {
+			case visiting:
+				start := slices.Index(path, d)
+				return append(slices.Clone(path[start:]), d)
+			case unvisited:
+				if cycle := visit(d); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[t] = visited
+		return nil
+	}
+	for _, t := range tables {
+		if state[t] == unvisited {
+			if cycle := visit(t); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package ncservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fkTestTenant struct {
+	TmId int    `gorm:"column:tm_id;primaryKey"`
+	Name string `gorm:"column:tm_name"`
+}
+
+type fkTestGroup struct {
+	GrId     int `gorm:"column:gr_id;primaryKey"`
+	TenantId int `gorm:"column:tm_id" fk:"tenant.tm_id"`
+	ParentId int `gorm:"column:parent_id" fk:"group.gr_id"`
+}
+
+// fakeRows has rows identified by "table.col=val"
+type fakeRows map[string]bool
+
+func (r fakeRows) Exists(ctx context.Context, table string, where []Value) (bool, error) {
+	return r[fmt.Sprintf("%s.%s=%v", table, where[0].Col, where[0].Val)], nil
+}
+
+func fkTestGraph(t *testing.T) *ForeignKeyGraph {
+	g := &ForeignKeyGraph{}
+	require.NoError(t, g.Register("extension", &planTestStruct{}))
+	require.NoError(t, g.Register("tenant", fkTestTenant{}))
+	require.NoError(t, g.Register("group", fkTestGroup{}))
+	return g
+}
+
+func TestForeignKeyGraph(t *testing.T) {
+	g := fkTestGraph(t)
+	assert.Equal(t, []string{"extension", "group", "tenant", "voicemail"}, g.Tables())
+	assert.Equal(t, []ForeignKey{{"extension", "tm_id", "tenant", "tm_id"}}, g.References("extension"))
+	assert.Equal(t, "voicemail.em_id -> extension.em_id", g.ReferencedBy("extension")[0].String())
+	assert.Len(t, g.ReferencedBy("tenant"), 2)
+
+	order, err := g.InsertOrder()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tenant", "extension", "group", "voicemail"}, order)
+	order, err = g.DeleteOrder("voicemail", "tenant", "extension")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"voicemail", "extension", "tenant"}, order)
+	order, err = g.InsertOrder("extension", "tenant", "extension", "tenant")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tenant", "extension"}, order)
+	assert.Nil(t, g.Cycles())
+}
+
+func TestForeignKeyCycles(t *testing.T) {
+	g := fkTestGraph(t)
+	require.NoError(t, g.Register("tenant", struct {
+		OwnerId int `gorm:"column:owner_id" fk:"voicemail.em_id"`
+	}{}))
+	_, err := g.InsertOrder()
+	var cycle CycleErr
+	require.True(t, errors.As(err, &cycle))
+	assert.Equal(t, "foreign key cycle extension -> tenant -> voicemail -> extension", err.Error())
+	assert.Equal(t, [][]string{{"extension", "tenant", "voicemail", "extension"}}, g.Cycles())
+
+	// without voicemail there is no cycle
+	order, err := g.InsertOrder("tenant", "extension")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tenant", "extension"}, order)
+}
+
+func TestCheckReferences(t *testing.T) {
+	g := fkTestGraph(t)
+	ctx := context.Background()
+	rows := fakeRows{"tenant.tm_id=3": true, "group.gr_id=5": true}
+	assert.NoError(t, g.CheckReferences(ctx, rows, "group", fkTestGroup{GrId: 1, TenantId: 3}))
+	assert.NoError(t, g.CheckReferences(ctx, rows, "group", &fkTestGroup{GrId: 1, TenantId: 3, ParentId: 5}))
+	err := g.CheckReferences(ctx, rows, "group", fkTestGroup{GrId: 1, TenantId: 4})
+	assert.True(t, errors.Is(err, ErrUser))
+	assert.Equal(t, "tenant 4 does not exist", err.Error())
+
+	// the registered foreign keys of the table apply to structs without fk tags
+	type groupName struct {
+		GrId     int    `gorm:"column:gr_id;primaryKey"`
+		TenantId int    `gorm:"column:tm_id"`
+		Name     string `gorm:"column:gr_name"`
+	}
+	assert.NoError(t, g.CheckReferences(ctx, rows, "group", groupName{GrId: 1, TenantId: 3}))
+	err = g.CheckReferences(ctx, rows, "group", groupName{GrId: 1, TenantId: 4})
+	assert.Equal(t, "tenant 4 does not exist", err.Error())
+	assert.NoError(t, (&ForeignKeyGraph{}).CheckReferences(ctx, rows, "group", groupName{GrId: 1, TenantId: 4}))
+
+	// and to fields of other tables
+	type extensionPin struct {
+		EmId   int    `gorm:"column:em_id;primaryKey"`
+		VmEmId int    `gorm:"column:em_id" table:"voicemail"`
+		VmPin  string `gorm:"column:vm_pin" table:"voicemail"`
+	}
+	err = g.CheckReferences(ctx, rows, "extension", extensionPin{EmId: 1, VmEmId: 7})
+	assert.Equal(t, "extension 7 does not exist", err.Error())
+
+	assert.NoError(t, g.CheckReferenced(ctx, rows, "tenant", fkTestTenant{TmId: 4}))
+	rows["group.tm_id=4"] = true
+	err = g.CheckReferenced(ctx, rows, "tenant", fkTestTenant{TmId: 4})
+	assert.True(t, errors.Is(err, ErrUser))
+	assert.Equal(t, "tenant 4 is still used by group", err.Error())
+	// parents are left to the database
+	rows["group.parent_id=5"] = true
+	assert.NoError(t, g.CheckReferenced(ctx, rows, "group", fkTestGroup{GrId: 5}))
+}
+
+func TestExistsStatement(t *testing.T) {
+	where := []Value{{Col: "tm_id", Val: 3}}
+	stmt, err := MySQL.Exists("tenant", where)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 1 FROM `tenant` WHERE `tm_id` = ? LIMIT 1", stmt.SQL)
+	stmt, err = SQLServer.Exists("tenant", where)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT TOP 1 1 FROM [tenant] WHERE [tm_id] = @p1", stmt.SQL)
+	assert.Equal(t, []any{3}, stmt.Args)
+}
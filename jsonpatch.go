@@ -0,0 +1,278 @@
+package ncservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchOp is a single operation of a JSON Patch document
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ApplyJsonPatch applies a JSON Patch (RFC 6902) document to a struct using the
+// same JSON names as ApiValues. Paths address fields, fields in nested structs
+// like /address/city and elements of slice fields like /tags/0 or /tags/- to
+// append. Either all operations are applied or none are.
+func ApplyJsonPatch(h any, patch []byte) ([]DiffVal, error) {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("ApplyJsonPatch: target must be a pointer to a struct")
+	}
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, ErrUserf("invalid json patch. %s", err)
+	}
+	doc := &patchDoc{
+		ref:    ref.Elem(),
+		schema: schemaOf(ref.Type()),
+		edits:  make(map[*fieldSchema]reflect.Value),
+	}
+	for i, op := range ops {
+		if err := doc.apply(op); err != nil {
+			return nil, ErrUserf("operation %d (%s %s) failed. %s", i, op.Op, op.Path, err)
+		}
+	}
+	before, err := ApiValues(h, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEdits(doc.ref, doc.changes()); err != nil {
+		return nil, err
+	}
+	after, err := ApiValues(h, nil)
+	if err != nil {
+		return nil, err
+	}
+	return DiffVals(before, after), nil
+}
+
+// patchDoc holds changed field values until all operations have succeeded
+type patchDoc struct {
+	ref    reflect.Value
+	schema *schema
+	edits  map[*fieldSchema]reflect.Value
+	order  []*fieldSchema
+}
+
+// patchTarget is what a JSON pointer resolves to, either a whole field or an
+// element of a slice field
+type patchTarget struct {
+	f     *fieldSchema
+	index string
+}
+
+func (t patchTarget) isElem() bool {
+	return t.index != ""
+}
+
+func (d *patchDoc) changes() []fieldEdit {
+	edits := make([]fieldEdit, 0, len(d.order))
+	for _, f := range d.order {
+		edits = append(edits, fieldEdit{index: f.index, val: d.edits[f]})
+	}
+	return edits
+}
+
+func (d *patchDoc) get(f *fieldSchema) reflect.Value {
+	if v, found := d.edits[f]; found {
+		return v
+	}
+	if v, valid := fieldByIndex(d.ref, f.index); valid {
+		return v
+	}
+	return reflect.Zero(f.Type)
+}
+
+func (d *patchDoc) set(f *fieldSchema, v reflect.Value) error {
+	if err := checkUpdateable(f); err != nil {
+		return err
+	}
+	if _, found := d.edits[f]; !found {
+		d.order = append(d.order, f)
+	}
+	d.edits[f] = v
+	return nil
+}
+
+func (d *patchDoc) resolve(pointer string) (patchTarget, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return patchTarget{}, fmt.Errorf("invalid path '%s'", pointer)
+	}
+	segs := strings.Split(pointer[1:], "/")
+	for i, seg := range segs {
+		segs[i] = strings.ReplaceAll(strings.ReplaceAll(seg, "~1", "/"), "~0", "~")
+	}
+	if f, found := d.schema.byApi[strings.Join(segs, ".")]; found {
+		return patchTarget{f: f}, nil
+	}
+	if len(segs) > 1 {
+		if f, found := d.schema.byApi[strings.Join(segs[:len(segs)-1], ".")]; found && f.Type.Kind() == reflect.Slice {
+			return patchTarget{f: f, index: segs[len(segs)-1]}, nil
+		}
+	}
+	return patchTarget{}, fmt.Errorf("unknown field %s", pointer)
+}
+
+// elemIndex parses an array index. When appending, "-" is the end of the slice.
+func elemIndex(s string, length int, appending bool) (int, error) {
+	if s == "-" && appending {
+		return length, nil
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("invalid index %s", s)
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid index %s", s)
+	}
+	max := length - 1
+	if appending {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("index %d out of range", i)
+	}
+	return i, nil
+}
+
+func (d *patchDoc) read(t patchTarget) (reflect.Value, error) {
+	v := d.get(t.f)
+	if !t.isElem() {
+		return v, nil
+	}
+	i, err := elemIndex(t.index, v.Len(), false)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return v.Index(i), nil
+}
+
+func (d *patchDoc) write(t patchTarget, val any, inserting bool) error {
+	if !t.isElem() {
+		if val == nil && !nullable(t.f.Type) {
+			return fmt.Errorf("%s cannot be null", t.f.json)
+		}
+		v, err := DefaultConverters.Convert(val, t.f.Type)
+		if err != nil {
+			return err
+		}
+		return d.set(t.f, v)
+	}
+	cur := d.get(t.f)
+	i, err := elemIndex(t.index, cur.Len(), inserting)
+	if err != nil {
+		return err
+	}
+	elem, err := DefaultConverters.Convert(val, t.f.Type.Elem())
+	if err != nil {
+		return err
+	}
+	var updated reflect.Value
+	if inserting {
+		updated = reflect.MakeSlice(t.f.Type, 0, cur.Len()+1)
+		updated = reflect.AppendSlice(updated, cur.Slice(0, i))
+		updated = reflect.Append(updated, elem)
+		updated = reflect.AppendSlice(updated, cur.Slice(i, cur.Len()))
+	} else {
+		// copied so the original slice is untouched until the patch succeeds
+		updated = reflect.MakeSlice(t.f.Type, cur.Len(), cur.Len())
+		reflect.Copy(updated, cur)
+		updated.Index(i).Set(elem)
+	}
+	return d.set(t.f, updated)
+}
+
+func (d *patchDoc) remove(t patchTarget) error {
+	if !t.isElem() {
+		if !nullable(t.f.Type) {
+			return fmt.Errorf("%s cannot be removed", t.f.json)
+		}
+		return d.set(t.f, reflect.Zero(t.f.Type))
+	}
+	cur := d.get(t.f)
+	i, err := elemIndex(t.index, cur.Len(), false)
+	if err != nil {
+		return err
+	}
+	updated := reflect.MakeSlice(t.f.Type, 0, cur.Len()-1)
+	updated = reflect.AppendSlice(updated, cur.Slice(0, i))
+	updated = reflect.AppendSlice(updated, cur.Slice(i+1, cur.Len()))
+	return d.set(t.f, updated)
+}
+
+func (d *patchDoc) apply(op jsonPatchOp) error {
+	path, err := d.resolve(op.Path)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return errors.New("missing value")
+		}
+		var val any
+		dec := json.NewDecoder(bytes.NewReader(op.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&val); err != nil {
+			return err
+		}
+		switch op.Op {
+		case "add":
+			return d.write(path, val, true)
+		case "replace":
+			return d.write(path, val, false)
+		}
+		return d.test(path, val)
+	case "remove":
+		return d.remove(path)
+	case "move", "copy":
+		from, err := d.resolve(op.From)
+		if err != nil {
+			return err
+		}
+		v, err := d.read(from)
+		if err != nil {
+			return err
+		}
+		val := v.Interface()
+		if op.Op == "move" {
+			if err := d.remove(from); err != nil {
+				return err
+			}
+		}
+		return d.write(path, val, true)
+	}
+	return fmt.Errorf("unsupported operation '%s'", op.Op)
+}
+
+func (d *patchDoc) test(t patchTarget, val any) error {
+	actual, err := d.read(t)
+	if err != nil {
+		return err
+	}
+	// values of another JSON type are never equal, "0" is not 0
+	equal := jsonTypeMatches(val, actual.Type())
+	if equal {
+		expected, err := DefaultConverters.Convert(val, actual.Type())
+		if err != nil {
+			return err
+		}
+		equal = reflect.DeepEqual(actual.Interface(), expected.Interface())
+	}
+	if !equal {
+		if actual = reflect.Indirect(actual); !actual.IsValid() {
+			return errors.New("value is null")
+		}
+		return fmt.Errorf("value is %v", actual.Interface())
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package ncservice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyJsonPatch(t *testing.T) {
+	tests := []struct {
+		patch    string
+		expected patchTestStruct
+		changed  []string
+	}{
+		{
+			patch:    `[{"op": "replace", "path": "/name", "value": "bob"}]`,
+			expected: patchTestStruct{Name: "bob", Tags: []string{"a", "b", "c"}},
+			changed:  []string{"name"},
+		},
+		{
+			patch:    `[{"op": "add", "path": "/tags/1", "value": "x"}, {"op": "add", "path": "/tags/-", "value": "z"}]`,
+			expected: patchTestStruct{Name: "joe", Tags: []string{"a", "x", "b", "c", "z"}},
+			changed:  []string{"tags"},
+		},
+		{
+			patch:    `[{"op": "remove", "path": "/tags/0"}]`,
+			expected: patchTestStruct{Name: "joe", Tags: []string{"b", "c"}},
+			changed:  []string{"tags"},
+		},
+		{
+			patch:    `[{"op": "replace", "path": "/tags/2", "value": "C"}]`,
+			expected: patchTestStruct{Name: "joe", Tags: []string{"a", "b", "C"}},
+			changed:  []string{"tags"},
+		},
+		{
+			patch:    `[{"op": "move", "from": "/tags/0", "path": "/tags/-"}]`,
+			expected: patchTestStruct{Name: "joe", Tags: []string{"b", "c", "a"}},
+			changed:  []string{"tags"},
+		},
+		{
+			patch:    `[{"op": "copy", "from": "/name", "path": "/address/city"}, {"op": "add", "path": "/email", "value": "e@x.com"}]`,
+			expected: patchTestStruct{Name: "joe", Email: Ptr("e@x.com"), Tags: []string{"a", "b", "c"}, Address: patchTestAddress{City: "joe"}},
+			changed:  []string{"email", "address.city"},
+		},
+		{
+			patch:    `[{"op": "test", "path": "/tags/1", "value": "b"}, {"op": "test", "path": "/count", "value": 0}, {"op": "remove", "path": "/tags"}]`,
+			expected: patchTestStruct{Name: "joe"},
+			changed:  []string{"tags"},
+		},
+		{
+			patch:    `[{"op": "add", "path": "/address~1zip", "value": "x"}]`,
+			expected: patchTestStruct{Name: "joe", Tags: []string{"a", "b", "c"}},
+		},
+	}
+	for _, test := range tests {
+		x := patchTestStruct{Name: "joe", Tags: []string{"a", "b", "c"}}
+		if test.changed == nil {
+			_, err := ApplyJsonPatch(&x, []byte(test.patch))
+			assert.Error(t, err, test.patch)
+			continue
+		}
+		diff, err := ApplyJsonPatch(&x, []byte(test.patch))
+		require.NoError(t, err, test.patch)
+		assert.Equal(t, test.expected, x, test.patch)
+		var changed []string
+		for _, d := range diff {
+			changed = append(changed, d.Col)
+		}
+		assert.Equal(t, test.changed, changed, test.patch)
+	}
+}
+
+func TestApplyJsonPatchErrors(t *testing.T) {
+	tests := []struct {
+		patch string
+		err   string
+	}{
+		{patch: `{}`, err: "invalid json patch. json: cannot unmarshal object into Go value of type []ncservice.jsonPatchOp"},
+		{patch: `[{"op": "add", "path": "/nope", "value": 1}]`, err: "operation 0 (add /nope) failed. unknown field /nope"},
+		{patch: `[{"op": "jump", "path": "/name"}]`, err: "operation 0 (jump /name) failed. unsupported operation 'jump'"},
+		{patch: `[{"op": "add", "path": "/name"}]`, err: "operation 0 (add /name) failed. missing value"},
+		{patch: `[{"op": "remove", "path": "/name"}]`, err: "operation 0 (remove /name) failed. name cannot be removed"},
+		{patch: `[{"op": "replace", "path": "/id", "value": 3}]`, err: "operation 0 (replace /id) failed. id cannot be updated"},
+		{patch: `[{"op": "remove", "path": "/tags/5"}]`, err: "operation 0 (remove /tags/5) failed. index 5 out of range"},
+		{patch: `[{"op": "replace", "path": "/tags/-", "value": "x"}]`, err: "operation 0 (replace /tags/-) failed. invalid index -"},
+		{patch: `[{"op": "replace", "path": "/name", "value": "x"}, {"op": "test", "path": "/tags/0", "value": "z"}]`, err: "operation 1 (test /tags/0) failed. value is a"},
+		{patch: `[{"op": "test", "path": "/email", "value": "x"}]`, err: "operation 0 (test /email) failed. value is null"},
+		{patch: `[{"op": "test", "path": "/count", "value": "0"}]`, err: "operation 0 (test /count) failed. value is 0"},
+		{patch: `[{"op": "test", "path": "/count", "value": false}]`, err: "operation 0 (test /count) failed. value is 0"},
+		{patch: `[{"op": "test", "path": "/name", "value": null}]`, err: "operation 0 (test /name) failed. value is joe"},
+		{patch: `[{"op": "test", "path": "/tags", "value": "a"}]`, err: "operation 0 (test /tags) failed. value is [a]"},
+	}
+	for _, test := range tests {
+		x := patchTestStruct{Name: "joe", Tags: []string{"a"}}
+		_, err := ApplyJsonPatch(&x, []byte(test.patch))
+		assert.EqualError(t, err, test.err, test.patch)
+		assert.True(t, errors.Is(err, ErrUser))
+		assert.Equal(t, patchTestStruct{Name: "joe", Tags: []string{"a"}}, x, "unchanged on error")
+	}
+}
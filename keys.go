@@ -0,0 +1,152 @@
+package ncservice
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// KeyValues reads the primary key values of h in the order the key fields are
+// declared. h can be a struct or a pointer to a struct.
+func KeyValues(h any) ([]Value, error) {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() == reflect.Ptr {
+		ref = ref.Elem()
+	}
+	s := schemaOf(ref.Type())
+	if len(s.keys) == 0 {
+		return nil, errNoKeys
+	}
+	keys := make([]Value, 0, len(s.keys))
+	for _, f := range s.keys {
+		k := Value{Table: f.table, Col: f.column}
+		if v, valid := fieldByIndex(ref, f.index); valid && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+			k.Val = v.Interface()
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// rowKeys are the KeyValues of h that identify the row in a WHERE. A key
+// without a value is an error as col = NULL never matches.
+func rowKeys(h any) ([]Value, error) {
+	keys, err := KeyValues(h)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func checkKeys(keys []Value) error {
+	for _, k := range keys {
+		if k.Val == nil {
+			return fmt.Errorf("key %s has no value", k.Col)
+		}
+	}
+	return nil
+}
+
+// KeysEqual tells if two structs identify the same row. They do not have to be
+// the same type as long as they have the same key columns.
+func KeysEqual(a any, b any) (bool, error) {
+	aKeys, err := KeyValues(a)
+	if err != nil {
+		return false, err
+	}
+	bKeys, err := KeyValues(b)
+	if err != nil {
+		return false, err
+	}
+	if len(aKeys) != len(bKeys) {
+		return false, nil
+	}
+	for i := range aKeys {
+		if aKeys[i].Col != bKeys[i].Col {
+			return false, nil
+		}
+		if !reflect.DeepEqual(keyValue(aKeys[i].Val), keyValue(bKeys[i].Val)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// keyValue follows a pointer key, nil for a nil pointer so nil keys only equal
+// other nil keys
+func keyValue(v any) any {
+	ref := reflect.Indirect(reflect.ValueOf(v))
+	if !ref.IsValid() {
+		return nil
+	}
+	return ref.Interface()
+}
+
+// keySeparator is not escaped in paths so keys can be used in URLs like
+// /tenant/3/user/3,17 but is escaped inside key values
+const keySeparator = ","
+
+// KeyString encodes the primary key of h into a single string suitable for cache
+// keys, URLs and cursors. Each key value is escaped and joined in the order the
+// keys are declared. Decode it with ParseKeyString.
+func KeyString(h any) (string, error) {
+	keys, err := KeyValues(h)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		if k.Val == nil {
+			return "", fmt.Errorf("key %s has no value", k.Col)
+		}
+		s, err := DefaultConverters.Convert(k.Val, reflect.TypeFor[string]())
+		if err != nil {
+			return "", fmt.Errorf("key %s. %w", k.Col, err)
+		}
+		parts[i] = url.QueryEscape(s.String())
+	}
+	return strings.Join(parts, keySeparator), nil
+}
+
+// ParseKeyString sets the primary key fields of h, a pointer to a struct, from
+// a string made with KeyString. Errors are user errors as the string likely came
+// from a request.
+func ParseKeyString(h any, key string) error {
+	keys, err := KeyValues(h)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(key, keySeparator)
+	if len(parts) != len(keys) {
+		return ErrUserf("expected %d key values but got %d", len(keys), len(parts))
+	}
+	vals := make([]Value, len(keys))
+	for i, k := range keys {
+		s, err := url.QueryUnescape(parts[i])
+		if err != nil {
+			return ErrUserf("invalid key %s. %s", k.Col, err)
+		}
+		vals[i] = Value{Table: k.Table, Col: k.Col, Val: s}
+	}
+	if err := SetValues(h, vals); err != nil {
+		return ErrUserf("invalid key. %s", err)
+	}
+	return nil
+}
+
+// KeyWhere builds the condition matching the primary key of h like
+// `a` = ? AND `b` = ?. There is no leading WHERE so it can be combined with other
+// conditions and SQL Server placeholders start at @p1.
+func (d Dialect) KeyWhere(h any) (Statement, error) {
+	keys, err := rowKeys(h)
+	if err != nil {
+		return Statement{}, err
+	}
+	b := &sqlBuilder{d: d}
+	b.conditions(keys)
+	return b.statement(), nil
+}
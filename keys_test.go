@@ -0,0 +1,126 @@
+package ncservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keysTestStruct struct {
+	TenantId int       `gorm:"column:tm_id;primaryKey"`
+	Name     string    `gorm:"column:name;primaryKey"`
+	Day      time.Time `gorm:"column:day;primaryKey"`
+	Notes    string    `gorm:"column:notes"`
+}
+
+func TestKeyValues(t *testing.T) {
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	x := keysTestStruct{TenantId: 3, Name: "a,b c", Day: day, Notes: "n"}
+
+	keys, err := KeyValues(x)
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Col: "tm_id", Val: 3}, {Col: "name", Val: "a,b c"}, {Col: "day", Val: day}}, keys)
+	assert.Equal(t, []string{"tm_id", "name", "day"}, GetPrimaryKeyColumn(x))
+	assert.Equal(t, []string{"tm_id", "name", "day"}, GetPrimaryKeyColumn(&x))
+
+	_, err = KeyValues(struct{ X int }{})
+	assert.Error(t, err)
+}
+
+func TestKeysEqual(t *testing.T) {
+	a := keysTestStruct{TenantId: 3, Name: "a", Notes: "x"}
+	b := &keysTestStruct{TenantId: 3, Name: "a", Notes: "y"}
+	equal, err := KeysEqual(a, b)
+	require.NoError(t, err)
+	assert.True(t, equal)
+
+	b.Name = "b"
+	equal, err = KeysEqual(a, b)
+	require.NoError(t, err)
+	assert.False(t, equal)
+
+	other := struct {
+		TenantId *int  `gorm:"column:tm_id;primaryKey"`
+		UserId   int64 `gorm:"column:us_id;primaryKey"`
+	}{TenantId: Ptr(3)}
+	equal, err = KeysEqual(a, other)
+	require.NoError(t, err)
+	assert.False(t, equal)
+}
+
+func TestKeysEqualNil(t *testing.T) {
+	type ptrKeys struct {
+		TenantId *int `gorm:"column:tm_id;primaryKey"`
+	}
+	equal, err := KeysEqual(ptrKeys{}, ptrKeys{})
+	require.NoError(t, err)
+	assert.True(t, equal)
+	equal, err = KeysEqual(ptrKeys{}, ptrKeys{TenantId: Ptr(0)})
+	require.NoError(t, err)
+	assert.False(t, equal)
+	equal, err = KeysEqual(ptrKeys{TenantId: Ptr(3)}, ptrKeys{})
+	require.NoError(t, err)
+	assert.False(t, equal)
+	equal, err = KeysEqual(ptrKeys{TenantId: Ptr(3)}, ptrKeys{TenantId: Ptr(3)})
+	require.NoError(t, err)
+	assert.True(t, equal)
+}
+
+func TestKeyString(t *testing.T) {
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	x := keysTestStruct{TenantId: 3, Name: "a,b c/d", Day: day}
+	s, err := KeyString(&x)
+	require.NoError(t, err)
+	assert.Equal(t, "3,a%2Cb+c%2Fd,2024-05-06T00%3A00%3A00Z", s)
+
+	var y keysTestStruct
+	require.NoError(t, ParseKeyString(&y, s))
+	equal, err := KeysEqual(x, y)
+	require.NoError(t, err)
+	assert.True(t, equal)
+
+	err = ParseKeyString(&y, "3,x")
+	assert.True(t, errors.Is(err, ErrUser))
+	assert.EqualError(t, err, "expected 3 key values but got 2")
+	err = ParseKeyString(&y, "x,y,2024-05-06")
+	assert.True(t, errors.Is(err, ErrUser))
+}
+
+func TestKeyWhere(t *testing.T) {
+	x := keysTestStruct{TenantId: 3, Name: "a"}
+	stmt, err := MySQL.KeyWhere(x)
+	require.NoError(t, err)
+	assert.Equal(t, "`tm_id` = ? AND `name` = ? AND `day` = ?", stmt.SQL)
+	assert.Equal(t, []any{3, "a", time.Time{}}, stmt.Args)
+
+	stmt, err = SQLServer.KeyWhere(x)
+	require.NoError(t, err)
+	assert.Equal(t, "[tm_id] = @p1 AND [name] = @p2 AND [day] = @p3", stmt.SQL)
+}
+
+func TestMissingKeyValue(t *testing.T) {
+	type ptrKeys struct {
+		TenantId *int   `gorm:"column:tm_id;primaryKey"`
+		Name     string `gorm:"column:name"`
+	}
+	x := &ptrKeys{Name: "a"}
+	_, err := MySQL.KeyWhere(x)
+	assert.EqualError(t, err, "key tm_id has no value")
+	_, err = MySQL.Update("t", x, nil)
+	assert.EqualError(t, err, "key tm_id has no value")
+	_, err = MySQL.Delete("t", x)
+	assert.EqualError(t, err, "key tm_id has no value")
+	_, err = MySQL.Select("t", x, nil)
+	assert.EqualError(t, err, "key tm_id has no value")
+	_, err = PlanUpdate("t", x, nil)
+	assert.EqualError(t, err, "key tm_id has no value")
+
+	x.TenantId = Ptr(0)
+	stmt, err := MySQL.Delete("t", x)
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `t` WHERE `tm_id` = ?", stmt.SQL)
+	assert.Equal(t, []any{Ptr(0)}, stmt.Args)
+}
@@ -0,0 +1,193 @@
+package ncservice
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords with argon2id into the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash so the parameters travel with the
+// hash and can be raised later.
+type PasswordHasher struct {
+	// Time is the number of passes over memory
+	Time uint32
+	// Memory is in KiB
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// DefaultPasswordHasher uses the parameters recommended in RFC 9106
+var DefaultPasswordHasher = &PasswordHasher{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+const argon2Prefix = "$argon2id$"
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// Hash encodes a password with a random salt
+func (h *PasswordHasher) Hash(plain string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(plain), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func isBcrypt(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
+// HashPasswords is a ValueReader for ReadValues that hashes the values of
+// fields tagged `password:"true"` so plain text never reaches the database.
+// Statements built here already hash them. Every value is hashed, so leave out
+// passwords that were not changed, like when a struct that was read is written
+// back, with FilterChangedPasswords.
+func (h *PasswordHasher) HashPasswords(v any, fld reflect.StructField) (any, error) {
+	if p := fld.Tag.Get("password"); p == "" || p == "false" {
+		return v, nil
+	}
+	ref := reflect.ValueOf(v)
+	if ref.Kind() == reflect.Ptr {
+		if ref.IsNil() {
+			return nil, nil
+		}
+		ref = ref.Elem()
+	}
+	if ref.Kind() != reflect.String {
+		return nil, fmt.Errorf("password field %s must be a string", fld.Name)
+	}
+	plain := ref.String()
+	if plain == "" {
+		return plain, nil
+	}
+	return h.Hash(plain)
+}
+
+// FilterChangedPasswords passes the values of password fields only when they
+// differ between before and after, both read with database columns, so
+// hashes that were read are not hashed again. Other fields always pass.
+func FilterChangedPasswords(before any, after any) (ValueFilter, error) {
+	bVals, err := Values(before, nil)
+	if err != nil {
+		return nil, err
+	}
+	aVals, err := Values(after, nil)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool)
+	for _, d := range DiffVals(bVals, aVals) {
+		changed[d.Col] = true
+	}
+	return func(v Value, fld reflect.StructField) bool {
+		if p := fld.Tag.Get("password"); p == "" || p == "false" {
+			return true
+		}
+		return changed[v.Col]
+	}, nil
+}
+
+// HashPasswords hashes password fields with the DefaultPasswordHasher
+func HashPasswords(v any, fld reflect.StructField) (any, error) {
+	return DefaultPasswordHasher.HashPasswords(v, fld)
+}
+
+// argon2Hash is a decoded PHC string
+type argon2Hash struct {
+	params PasswordHasher
+	salt   []byte
+	key    []byte
+}
+
+func decodeArgon2(encoded string) (argon2Hash, error) {
+	var h argon2Hash
+	parts := strings.Split(strings.TrimPrefix(encoded, argon2Prefix), "$")
+	if len(parts) != 4 {
+		return h, errInvalidHash
+	}
+	if parts[0] != fmt.Sprintf("v=%d", argon2.Version) {
+		return h, errInvalidHash
+	}
+	p := &h.params
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return h, errInvalidHash
+	}
+	// reject anything after the parameters and values argon2 panics on
+	if fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads) != parts[1] || p.Time < 1 || p.Threads < 1 {
+		return h, errInvalidHash
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return h, errInvalidHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return h, errInvalidHash
+	}
+	if len(h.salt) == 0 || len(h.key) == 0 {
+		return h, errInvalidHash
+	}
+	h.params.SaltLen = uint32(len(h.salt))
+	h.params.KeyLen = uint32(len(h.key))
+	return h, nil
+}
+
+// Verify checks a password against a hash from Hash or bcrypt. When the password
+// matches but the hash uses other parameters or bcrypt, the password is hashed
+// again with the current parameters and returned so it can be stored.
+func (h *PasswordHasher) Verify(encoded string, plain string) (bool, string, error) {
+	valid, current, err := h.verify(encoded, plain)
+	if err != nil || !valid || current {
+		return valid, "", err
+	}
+	rehashed, err := h.Hash(plain)
+	return true, rehashed, err
+}
+
+// verify checks a password and tells if the hash uses the parameters of h
+func (h *PasswordHasher) verify(encoded string, plain string) (valid bool, current bool, err error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plain))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return err == nil, false, err
+	}
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		return false, false, errInvalidHash
+	}
+	decoded, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	p := decoded.params
+	key := argon2.IDKey([]byte(plain), decoded.salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(key, decoded.key) != 1 {
+		return false, false, nil
+	}
+	return true, p == *h, nil
+}
+
+// VerifyPassword checks a password against a hash using the parameters of the
+// hash itself. Use Verify to also upgrade old hashes.
+func VerifyPassword(encoded string, plain string) (bool, error) {
+	valid, _, err := DefaultPasswordHasher.verify(encoded, plain)
+	return valid, err
+}
@@ -0,0 +1,228 @@
+package ncservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type passwordTestStruct struct {
+	ID      int     `json:"id" gorm:"column:id;primaryKey"`
+	Name    string  `json:"name" gorm:"column:name"`
+	Pass    string  `json:"pass" gorm:"column:pass" password:"true"`
+	Pin     *string `json:"pin" gorm:"column:pin" password:"true"`
+	Recover struct {
+		Answer string `json:"answer" gorm:"column:answer" password:"true"`
+	} `json:"recover" gorm:"embedded;embeddedPrefix:recover_"`
+}
+
+// testHasher keeps the tests fast
+var testHasher = &PasswordHasher{Time: 1, Memory: 64, Threads: 1, KeyLen: 16, SaltLen: 8}
+
+func TestHashPasswords(t *testing.T) {
+	x := &passwordTestStruct{ID: 1, Name: "john", Pass: "s3cret", Pin: Ptr("1234")}
+	x.Recover.Answer = "fluffy"
+	vals, err := ReadValues(x, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: testHasher.HashPasswords})
+	require.NoError(t, err)
+	require.Len(t, vals, 5)
+	for _, v := range vals {
+		s := fmt.Sprint(v.Val)
+		for _, plain := range []string{"s3cret", "1234", "fluffy"} {
+			assert.NotContains(t, s, plain, v.Col)
+		}
+	}
+	assert.Equal(t, "john", vals[1].Val)
+	for _, v := range vals[2:] {
+		assert.True(t, strings.HasPrefix(v.Val.(string), "$argon2id$v=19$m=64,t=1,p=1$"), v.Col)
+	}
+	valid, err := VerifyPassword(vals[2].Val.(string), "s3cret")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	valid, err = VerifyPassword(vals[3].Val.(string), "1234")
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	// values that look like hashes are still hashed
+	x.Pass = "$argon2id$hunter2"
+	x.Pin = Ptr(vals[3].Val.(string))
+	again, err := ReadValues(x, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: testHasher.HashPasswords})
+	require.NoError(t, err)
+	assert.NotContains(t, again[2].Val, "hunter2")
+	assert.NotEqual(t, vals[3].Val, again[3].Val)
+
+	// the same password gets a different salt every time
+	h1, err := testHasher.Hash("s3cret")
+	require.NoError(t, err)
+	assert.NotEqual(t, vals[2].Val, h1)
+}
+
+// useHasher swaps the DefaultPasswordHasher for the duration of a test
+func useHasher(t *testing.T, h *PasswordHasher) {
+	prev := DefaultPasswordHasher
+	DefaultPasswordHasher = h
+	t.Cleanup(func() { DefaultPasswordHasher = prev })
+}
+
+func TestHashOnWrite(t *testing.T) {
+	useHasher(t, testHasher)
+	x := &passwordTestStruct{ID: 1, Name: "john", Pass: "s3cret"}
+	hashed := func(args []any) {
+		t.Helper()
+		n := 0
+		for _, arg := range args {
+			if s, isText := arg.(string); isText && strings.HasPrefix(s, argon2Prefix) {
+				valid, err := VerifyPassword(s, "s3cret")
+				require.NoError(t, err)
+				assert.True(t, valid)
+				n++
+			}
+			assert.NotEqual(t, "s3cret", arg)
+		}
+		assert.Equal(t, 1, n)
+	}
+	stmt, err := MySQL.Insert("users", x, nil)
+	require.NoError(t, err)
+	hashed(stmt.Args)
+	stmt, err = SQLServer.Upsert("users", x, nil)
+	require.NoError(t, err)
+	hashed(stmt.Args)
+	plan, err := PlanInsert("users", x, nil)
+	require.NoError(t, err)
+	require.Len(t, plan, 1)
+	var vals []any
+	for _, v := range plan[0].Values {
+		vals = append(vals, v.Val)
+	}
+	hashed(vals)
+	chunks, _, err := BulkInsert{Dialect: MySQL, Table: "users"}.Chunks([]passwordTestStruct{*x})
+	require.NoError(t, err)
+	hashed(chunks[0].Args)
+	assert.Equal(t, "s3cret", x.Pass)
+
+	// a stored hash written back is left out with FilterChangedPasswords
+	before := &passwordTestStruct{ID: 1, Name: "john", Pass: chunks[0].Args[2].(string)}
+	after := *before
+	after.Name = "jim"
+	f, err := FilterChangedPasswords(before, &after)
+	require.NoError(t, err)
+	stmt, err = MySQL.Update("users", &after, f)
+	require.NoError(t, err)
+	assert.Equal(t, "UPDATE `users` SET `name` = ? WHERE `id` = ?", stmt.SQL)
+	after.Pass = "s3cret"
+	f, err = FilterChangedPasswords(before, &after)
+	require.NoError(t, err)
+	stmt, err = MySQL.Update("users", &after, f)
+	require.NoError(t, err)
+	hashed(stmt.Args)
+
+	v := &struct {
+		passwordTestStruct
+		Version int `json:"lastModified" gorm:"column:version"`
+	}{passwordTestStruct: *x}
+	db := &affectingExecer{affected: 1}
+	require.NoError(t, UpdateIfUnmodified(context.Background(), db, MySQL, "users", v, nil))
+	hashed(db.args)
+}
+
+func TestHashPasswordsNotString(t *testing.T) {
+	type bad struct {
+		Pass int `gorm:"column:pass" password:"true"`
+	}
+	_, err := ReadValues(&bad{Pass: 1}, ReadValueOptions{ColumnMapper: DatabaseColumns, Prefixer: DatabasePrefix, Reader: HashPasswords})
+	assert.Error(t, err)
+}
+
+func TestPasswordVerify(t *testing.T) {
+	encoded, err := testHasher.Hash("s3cret")
+	require.NoError(t, err)
+
+	valid, rehashed, err := testHasher.Verify(encoded, "s3cret")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.Empty(t, rehashed)
+
+	valid, rehashed, err = testHasher.Verify(encoded, "wrong")
+	require.NoError(t, err)
+	assert.False(t, valid)
+	assert.Empty(t, rehashed)
+
+	_, _, err = testHasher.Verify("s3cret", "s3cret")
+	assert.Error(t, err)
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=64,t=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=64,t=1,p=1$$aGFzaGhhc2g",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=64,t=1,p=1x$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$hunter2",
+	} {
+		_, _, err = testHasher.Verify(encoded, "s3cret")
+		assert.ErrorIs(t, err, errInvalidHash, encoded)
+	}
+}
+
+func TestFilterChangedPasswords(t *testing.T) {
+	stored, err := testHasher.Hash("s3cret")
+	require.NoError(t, err)
+	before := &passwordTestStruct{ID: 1, Name: "john", Pass: stored, Pin: Ptr(stored)}
+	after := *before
+	after.Name = "jim"
+	after.Pin = Ptr("4321")
+	f, err := FilterChangedPasswords(before, &after)
+	require.NoError(t, err)
+	vals, err := ReadValues(&after, ReadValueOptions{
+		Filter:       f,
+		ColumnMapper: DatabaseColumns,
+		Prefixer:     DatabasePrefix,
+		Reader:       testHasher.HashPasswords,
+	})
+	require.NoError(t, err)
+	require.Len(t, vals, 3)
+	assert.Equal(t, "id", vals[0].Col)
+	assert.Equal(t, "jim", vals[1].Val)
+	assert.Equal(t, "pin", vals[2].Col)
+	valid, err := VerifyPassword(vals[2].Val.(string), "4321")
+	require.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestPasswordRehash(t *testing.T) {
+	encoded, err := testHasher.Hash("s3cret")
+	require.NoError(t, err)
+
+	// raised parameters rehash on a successful verify
+	upgraded := *testHasher
+	upgraded.Time = 2
+	valid, rehashed, err := upgraded.Verify(encoded, "s3cret")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.True(t, strings.HasPrefix(rehashed, "$argon2id$v=19$m=64,t=2,p=1$"))
+	valid, rehashed, err = upgraded.Verify(rehashed, "s3cret")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.Empty(t, rehashed)
+
+	// but not on a failed one
+	_, rehashed, err = upgraded.Verify(encoded, "wrong")
+	require.NoError(t, err)
+	assert.Empty(t, rehashed)
+
+	// bcrypt hashes are verified and moved to argon2id
+	legacy, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	valid, rehashed, err = testHasher.Verify(string(legacy), "s3cret")
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.True(t, strings.HasPrefix(rehashed, "$argon2id$"))
+	valid, rehashed, err = testHasher.Verify(string(legacy), "wrong")
+	require.NoError(t, err)
+	assert.False(t, valid)
+	assert.Empty(t, rehashed)
+}
@@ -0,0 +1,307 @@
+package ncservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7396) document to a struct
+// using the same JSON names as ApiValues. Members set to null clear the field
+// which is only allowed for pointers, slices and maps, including pointers to
+// nested structs. Objects are merged into nested structs and maps. Values must
+// have the JSON type encoding/json would decode into the field. Nothing is
+// changed if any part of the patch is invalid.
+func ApplyMergePatch(h any, patch []byte) ([]DiffVal, error) {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("ApplyMergePatch: target must be a pointer to a struct")
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, ErrUserf("invalid merge patch. %s", err)
+	}
+	obj, valid := doc.(map[string]any)
+	if !valid {
+		return nil, ErrUserf("merge patch must be a JSON object")
+	}
+	s := schemaOf(ref.Type())
+	var edits []fieldEdit
+	if err := mergeEdits(s, ref.Elem(), "", obj, &edits); err != nil {
+		return nil, err
+	}
+	before, err := ApiValues(h, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEdits(ref.Elem(), edits); err != nil {
+		return nil, err
+	}
+	after, err := ApiValues(h, nil)
+	if err != nil {
+		return nil, err
+	}
+	return DiffVals(before, after), nil
+}
+
+// fieldEdit is a validated change to a single field, or a nested struct
+// pointer, the value is already converted to the field's type
+type fieldEdit struct {
+	index []int
+	val   reflect.Value
+}
+
+// sortedNames are the member names of a JSON object sorted so errors are
+// predictable
+func sortedNames(obj map[string]any) []string {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mergeEdits(s *schema, ref reflect.Value, prefix string, obj map[string]any, edits *[]fieldEdit) error {
+	for _, name := range sortedNames(obj) {
+		path := prefix + name
+		patchVal := obj[name]
+		if f, found := s.byApi[path]; found {
+			if err := checkUpdateable(f); err != nil {
+				return err
+			}
+			if patchVal == nil && !nullable(f.Type) {
+				return ErrUserf("%s cannot be null", path)
+			}
+			var v reflect.Value
+			var err error
+			if x, isObj := patchVal.(map[string]any); isObj && f.Type.Kind() == reflect.Map {
+				cur, _ := fieldByIndex(ref, f.index)
+				v, err = mergeMap(path, cur, f.Type, x)
+			} else {
+				v, err = convertJson(path, patchVal, f.Type)
+			}
+			if err != nil {
+				return err
+			}
+			*edits = append(*edits, fieldEdit{index: f.index, val: v})
+			continue
+		}
+		nested := s.nestedFields(path)
+		if len(nested) == 0 {
+			return ErrUserf("unknown field %s", path)
+		}
+		switch x := patchVal.(type) {
+		case map[string]any:
+			if err := mergeEdits(s, ref, path+".", x, edits); err != nil {
+				return err
+			}
+		case nil:
+			// removing the whole object sets the pointer to it to nil
+			parent, index := nestedParent(nested[0], path)
+			if parent.Type.Kind() != reflect.Ptr {
+				return ErrUserf("%s cannot be null", path)
+			}
+			for _, f := range nested {
+				if err := checkUpdateable(f); err != nil {
+					return err
+				}
+			}
+			*edits = append(*edits, fieldEdit{index: index, val: reflect.Zero(parent.Type)})
+		default:
+			return ErrUserf("%s must be an object", path)
+		}
+	}
+	return nil
+}
+
+// nestedFields are the fields inside a nested struct addressed by a JSON path
+func (s *schema) nestedFields(path string) []*fieldSchema {
+	var nested []*fieldSchema
+	for _, f := range s.fields {
+		if f.hasJson && strings.HasPrefix(f.json, path+".") {
+			nested = append(nested, f)
+		}
+	}
+	return nested
+}
+
+// nestedParent is the nested struct field addressed by a JSON path that holds
+// f, along with its index from the root struct
+func nestedParent(f *fieldSchema, path string) (reflect.StructField, []int) {
+	for k := range f.parents {
+		held := structField{parents: f.parents[:k+1]}
+		if held.prefix(ApiPrefix) == path+"." {
+			return f.parents[k], f.index[:k+1]
+		}
+	}
+	return reflect.StructField{}, nil
+}
+
+// mergeMap merges a JSON object into a copy of a map as RFC 7396 does, members
+// set to null are deleted and objects are merged into map values
+func mergeMap(path string, cur reflect.Value, t reflect.Type, obj map[string]any) (reflect.Value, error) {
+	merged := reflect.MakeMapWithSize(t, len(obj))
+	if cur.IsValid() && !cur.IsNil() {
+		iter := cur.MapRange()
+		for iter.Next() {
+			merged.SetMapIndex(iter.Key(), iter.Value())
+		}
+	}
+	for _, name := range sortedNames(obj) {
+		elemPath := path + "." + name
+		key, err := DefaultConverters.Convert(name, t.Key())
+		if err != nil {
+			return reflect.Value{}, ErrUserf("invalid key for %s. %s", elemPath, err)
+		}
+		patchVal := obj[name]
+		if patchVal == nil {
+			merged.SetMapIndex(key, reflect.Value{})
+			continue
+		}
+		var v reflect.Value
+		x, isObj := patchVal.(map[string]any)
+		switch {
+		case isObj && t.Elem().Kind() == reflect.Map:
+			v, err = mergeMap(elemPath, merged.MapIndex(key), t.Elem(), x)
+		case isObj && t.Elem().Kind() == reflect.Interface:
+			var target any
+			if old := merged.MapIndex(key); old.IsValid() {
+				target = old.Interface()
+			}
+			v = reflect.ValueOf(mergeJson(target, x))
+		default:
+			v, err = convertJson(elemPath, patchVal, t.Elem())
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		merged.SetMapIndex(key, v)
+	}
+	return merged, nil
+}
+
+// mergeJson is RFC 7396 for values without a Go type
+func mergeJson(target any, patch any) any {
+	obj, isObj := patch.(map[string]any)
+	if !isObj {
+		return patch
+	}
+	merged := make(map[string]any)
+	if t, isMap := target.(map[string]any); isMap {
+		maps.Copy(merged, t)
+	}
+	for name, v := range obj {
+		if v == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = mergeJson(merged[name], v)
+		}
+	}
+	return merged
+}
+
+// convertJson converts a value decoded from JSON with UseNumber to t after
+// checking it has a JSON type encoding/json would decode into t
+func convertJson(path string, v any, t reflect.Type) (reflect.Value, error) {
+	if !jsonTypeMatches(v, t) {
+		return reflect.Value{}, ErrUserf("invalid value for %s. JSON %s given for %s", path, jsonTypeName(v), t)
+	}
+	out, err := DefaultConverters.Convert(v, t)
+	if err != nil {
+		return reflect.Value{}, ErrUserf("invalid value for %s. %s", path, err)
+	}
+	return out, nil
+}
+
+// jsonTypeMatches tells if a value decoded from JSON with UseNumber has a type
+// that encoding/json would decode into t, a string for a string or a number for
+// a number but not the other way around
+func jsonTypeMatches(v any, t reflect.Type) bool {
+	if v == nil {
+		return nullable(t)
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return true
+	}
+	switch v.(type) {
+	case string:
+		return t.Kind() == reflect.String || isBytes(t) || reflect.PointerTo(t).Implements(textUnmarshalerType) ||
+			reflect.PointerTo(t).Implements(scannerType)
+	case json.Number:
+		return isInt(t) || isUint(t) || isFloat(t) || reflect.PointerTo(t).Implements(scannerType)
+	case bool:
+		return t.Kind() == reflect.Bool || reflect.PointerTo(t).Implements(scannerType)
+	case map[string]any:
+		return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+	case []any:
+		return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+	}
+	return false
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// checkUpdateable rejects keys and fields gorm considers read only which is
+// how fields joined from other tables are generated.
+func checkUpdateable(f *fieldSchema) error {
+	if f.key {
+		return ErrUserf("%s cannot be updated", f.json)
+	}
+	if f.readOnly() {
+		return ErrUserf("%s cannot be updated", f.json)
+	}
+	return nil
+}
+
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+func applyEdits(ref reflect.Value, edits []fieldEdit) error {
+	for _, e := range edits {
+		if e.val.IsZero() {
+			// no need to allocate nested structs just to clear a field
+			if field, valid := fieldByIndex(ref, e.index); valid {
+				field.Set(e.val)
+			}
+			continue
+		}
+		field, err := fieldByIndexAlloc(ref, e.index)
+		if err != nil {
+			return err
+		}
+		field.Set(e.val)
+	}
+	return nil
+}
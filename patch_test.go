@@ -0,0 +1,131 @@
+package ncservice
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type patchTestAddress struct {
+	City string  `json:"city" gorm:"column:city"`
+	Zip  *string `json:"zip" gorm:"column:zip"`
+}
+
+type patchTestStruct struct {
+	ID         int              `json:"id" gorm:"column:id;primaryKey"`
+	Name       string           `json:"name" gorm:"column:name"`
+	Email      *string          `json:"email" gorm:"column:email"`
+	Count      int              `json:"count" gorm:"column:cnt"`
+	Tags       []string         `json:"tags" gorm:"column:tags"`
+	Address    patchTestAddress `json:"address" gorm:"embedded;embeddedPrefix:addr_"`
+	TenantName string           `json:"tenantName" gorm:"column:tm_name;->" table:"tenant"`
+}
+
+type patchTestAccount struct {
+	ID      int               `json:"id" gorm:"column:id;primaryKey"`
+	Billing *patchTestAddress `json:"billing" gorm:"embedded;embeddedPrefix:bill_"`
+	Limits  map[string]int    `json:"limits" gorm:"column:limits;serializer:json"`
+	Attrs   map[string]any    `json:"attrs" gorm:"column:attrs;serializer:json"`
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	x := patchTestStruct{
+		ID:      1,
+		Name:    "joe",
+		Email:   Ptr("joe@example.com"),
+		Count:   3,
+		Tags:    []string{"a"},
+		Address: patchTestAddress{City: "Springfield", Zip: Ptr("12345")},
+	}
+	diff, err := ApplyMergePatch(&x, []byte(`{
+		"name": "bob",
+		"email": null,
+		"count": 4,
+		"tags": ["b", "c"],
+		"address": {"city": "Shelbyville"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", x.Name)
+	assert.Nil(t, x.Email)
+	assert.Equal(t, 4, x.Count)
+	assert.Equal(t, []string{"b", "c"}, x.Tags)
+	assert.Equal(t, "Shelbyville", x.Address.City)
+	assert.Equal(t, "12345", *x.Address.Zip)
+
+	var cols []string
+	for _, d := range diff {
+		cols = append(cols, d.Col)
+	}
+	assert.Equal(t, []string{"name", "email", "count", "tags", "address.city"}, cols)
+
+	diff, err = ApplyMergePatch(&x, []byte(`{"name": "bob"}`))
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
+
+func TestApplyMergePatchNested(t *testing.T) {
+	limits := map[string]int{"calls": 2, "lines": 3}
+	x := patchTestAccount{
+		Limits: limits,
+		Attrs:  map[string]any{"color": "red", "size": map[string]any{"w": 1.0, "h": 2.0}},
+	}
+	_, err := ApplyMergePatch(&x, []byte(`{
+		"limits": {"calls": 5, "lines": null, "users": 1},
+		"attrs": {"color": null, "size": {"h": null, "d": 3}, "shape": "round"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"calls": 5, "users": 1}, x.Limits)
+	assert.Equal(t, map[string]any{"size": map[string]any{"w": 1.0, "d": json.Number("3")}, "shape": "round"}, x.Attrs)
+	assert.Equal(t, map[string]int{"calls": 2, "lines": 3}, limits, "original map is copied")
+
+	_, err = ApplyMergePatch(&x, []byte(`{"limits": {"calls": "6"}}`))
+	assert.EqualError(t, err, "invalid value for limits.calls. JSON string given for int")
+	assert.Equal(t, 5, x.Limits["calls"])
+
+	_, err = ApplyMergePatch(&x, []byte(`{"limits": null}`))
+	require.NoError(t, err)
+	assert.Nil(t, x.Limits)
+
+	// a nested struct pointer is allocated by an object and cleared by null
+	diff, err := ApplyMergePatch(&x, []byte(`{"billing": {"city": "Capital City"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &patchTestAddress{City: "Capital City"}, x.Billing)
+	assert.Len(t, diff, 1)
+	diff, err = ApplyMergePatch(&x, []byte(`{"billing": null}`))
+	require.NoError(t, err)
+	assert.Nil(t, x.Billing)
+	assert.Len(t, diff, 1)
+}
+
+func TestApplyMergePatchErrors(t *testing.T) {
+	tests := []struct {
+		patch string
+		err   string
+	}{
+		{patch: `[]`, err: "merge patch must be a JSON object"},
+		{patch: `{`, err: "invalid merge patch. unexpected EOF"},
+		{patch: `{"nope": 1}`, err: "unknown field nope"},
+		{patch: `{"address": {"nope": 1}}`, err: "unknown field address.nope"},
+		{patch: `{"address": 1}`, err: "address must be an object"},
+		{patch: `{"id": 2}`, err: "id cannot be updated"},
+		{patch: `{"tenantName": "x"}`, err: "tenantName cannot be updated"},
+		{patch: `{"count": null}`, err: "count cannot be null"},
+		{patch: `{"name": "ok", "count": "x"}`, err: "invalid value for count. JSON string given for int"},
+		{patch: `{"name": 12}`, err: "invalid value for name. JSON number given for string"},
+		{patch: `{"count": 1.5}`, err: `invalid value for count. strconv.ParseInt: parsing "1.5": invalid syntax`},
+		{patch: `{"tags": "a"}`, err: "invalid value for tags. JSON string given for []string"},
+		{patch: `{"tags": [1]}`, err: "invalid value for tags. json: cannot unmarshal number into .0 of type string"},
+		{patch: `{"email": true}`, err: "invalid value for email. JSON boolean given for *string"},
+		{patch: `{"address": null}`, err: "address cannot be null"},
+	}
+	for _, test := range tests {
+		x := patchTestStruct{Name: "orig"}
+		_, err := ApplyMergePatch(&x, []byte(test.patch))
+		assert.EqualError(t, err, test.err, test.patch)
+		assert.True(t, errors.Is(err, ErrUser), test.patch)
+		assert.Equal(t, "orig", x.Name, "unchanged on error")
+	}
+}
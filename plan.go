@@ -0,0 +1,276 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WriteOp is the kind of write done to a single table
+type WriteOp int
+
+const (
+	WriteInsert WriteOp = iota
+	WriteUpdate
+)
+
+func (op WriteOp) String() string {
+	if op == WriteUpdate {
+		return "update"
+	}
+	return "insert"
+}
+
+// TableWrite is the part of a struct that is written to a single table
+type TableWrite struct {
+	Table string
+	Op    WriteOp
+	// Values are the columns to insert or to set on update
+	Values []Value
+	// Keys identify the row on update. On inserts into a related table they are
+	// the join columns and are also included in Values.
+	Keys []Value
+}
+
+// WritePlan is the ordered list of writes to store a struct whose fields are
+// spread across tables using the `table` tag. Tables that are referenced by
+// another table are written before the tables referencing them. Fields gorm
+// does not write, tagged -> or -, are left out, password fields are hashed and
+// encrypted fields are encrypted.
+type WritePlan []TableWrite
+
+// Execer is satisfied by *sql.DB, *sql.Tx, *sqlx.DB and *sqlx.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// TxBeginner is satisfied by *sql.DB and *sqlx.DB
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// PlanInsert splits the values of h that pass the filter into inserts for each
+// table. table is the table of all the fields that have no `table` tag. Rows of
+// tables the main table references, like a lookup table, have to exist already
+// so they are never inserted, their writable fields are only updated.
+func PlanInsert(table string, h any, f ValueFilter) (WritePlan, error) {
+	return planWrites(WriteInsert, table, h, f)
+}
+
+// PlanUpdate splits the values of h that pass the filter into updates for each
+// table. table is the table of all the fields that have no `table` tag.
+func PlanUpdate(table string, h any, f ValueFilter) (WritePlan, error) {
+	return planWrites(WriteUpdate, table, h, f)
+}
+
+// tableGroup are the values of a single table while planning
+type tableGroup struct {
+	table  string
+	vals   []Value
+	fields []*fieldSchema
+	keys   []Value
+	// before is true when the main table references this table and therefore
+	// this table has to be written first
+	before bool
+}
+
+func (g *tableGroup) keyIndex(col string) int {
+	return slices.IndexFunc(g.keys, func(k Value) bool { return k.Col == col })
+}
+
+func planWrites(op WriteOp, table string, h any, f ValueFilter) (WritePlan, error) {
+	// read all the values to find join columns even if the filter excludes them
+	allVals, allFields, err := readFieldValues(h, ReadValueOptions{
+		ColumnMapper: DatabaseColumns,
+		Prefixer:     DatabasePrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []*tableGroup
+	group := func(name string) *tableGroup {
+		for _, g := range groups {
+			if g.table == name {
+				return g
+			}
+		}
+		g := &tableGroup{table: name}
+		groups = append(groups, g)
+		return g
+	}
+	main := group(table)
+	for i, v := range allVals {
+		g := main
+		if v.Table != "" {
+			g = group(v.Table)
+		}
+		g.vals = append(g.vals, v)
+		g.fields = append(g.fields, allFields[i])
+	}
+
+	for i, f := range main.fields {
+		if f.key {
+			main.keys = append(main.keys, main.vals[i])
+		}
+	}
+	if op == WriteUpdate {
+		if len(main.keys) == 0 {
+			return nil, errNoKeys
+		}
+		if err := checkKeys(main.keys); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range groups[1:] {
+		if err := joinTable(table, main, g); err != nil {
+			return nil, err
+		}
+	}
+
+	var plan WritePlan
+	add := func(g *tableGroup) error {
+		gOp := op
+		if g.before {
+			gOp = WriteUpdate
+		}
+		var vals []Value
+		if g != main && gOp == WriteInsert {
+			// join columns take the value of the table they relate to
+			vals = append(vals, g.keys...)
+		}
+		for i, v := range g.vals {
+			if g.keyIndex(v.Col) >= 0 && (g != main || gOp == WriteUpdate) {
+				continue
+			}
+			if g.fields[i].readOnly() {
+				continue
+			}
+			if f == nil || f(v, g.fields[i].StructField) {
+				var err error
+				if v.Val, err = protectValue(g.fields[i], v.Val); err != nil {
+					return err
+				}
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) == 0 || (g != main && gOp == WriteInsert && len(vals) == len(g.keys)) {
+			// nothing to write besides how the tables are joined
+			return nil
+		}
+		plan = append(plan, TableWrite{
+			Table:  g.table,
+			Op:     gOp,
+			Values: vals,
+			Keys:   g.keys,
+		})
+		return nil
+	}
+	for _, g := range groups[1:] {
+		if g.before {
+			if err := add(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := add(main); err != nil {
+		return nil, err
+	}
+	for _, g := range groups[1:] {
+		if !g.before {
+			if err := add(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// joinTable finds the columns that relate another table to the main table from
+// the `fk` tags. Either a field in the other table references the main table
+// or a field in the main table references the other table.
+func joinTable(table string, main *tableGroup, g *tableGroup) error {
+	for _, f := range g.fields {
+		fkTable, fkCol, valid := splitForeignKey(f.fk)
+		if !valid || fkTable != table {
+			continue
+		}
+		i := slices.IndexFunc(main.vals, func(v Value) bool { return v.Col == fkCol })
+		if i < 0 {
+			return fmt.Errorf("%s.%s references %s.%s which is not a field", g.table, f.column, table, fkCol)
+		}
+		g.keys = append(g.keys, Value{Table: g.table, Col: f.column, Val: main.vals[i].Val})
+	}
+	if len(g.keys) > 0 {
+		return nil
+	}
+	for i, f := range main.fields {
+		fkTable, fkCol, valid := splitForeignKey(f.fk)
+		if !valid || fkTable != g.table {
+			continue
+		}
+		g.keys = append(g.keys, Value{Table: g.table, Col: fkCol, Val: main.vals[i].Val})
+		g.before = true
+	}
+	if len(g.keys) == 0 {
+		return fmt.Errorf("no fk tag relates table %s to %s", g.table, table)
+	}
+	return nil
+}
+
+// splitForeignKey splits an fk tag of the form table.col
+func splitForeignKey(fk string) (string, string, bool) {
+	i := strings.LastIndex(fk, ".")
+	if i <= 0 || i == len(fk)-1 {
+		return "", "", false
+	}
+	return fk[:i], fk[i+1:], true
+}
+
+// Statements renders each write in the plan in order
+func (p WritePlan) Statements(d Dialect) ([]Statement, error) {
+	stmts := make([]Statement, 0, len(p))
+	for _, w := range p {
+		var stmt Statement
+		var err error
+		if w.Op == WriteUpdate {
+			stmt, err = d.update(w.Table, w.Values, w.Keys)
+		} else {
+			stmt, err = d.insert(w.Table, w.Values)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// Exec runs all the writes in order. Pass a transaction to have the writes
+// succeed or fail together or use ExecTx.
+func (p WritePlan) Exec(ctx context.Context, db Execer, d Dialect) error {
+	stmts, err := p.Statements(d)
+	if err != nil {
+		return err
+	}
+	for i, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt.SQL, stmt.Args...); err != nil {
+			return fmt.Errorf("%s of %s failed. %w", p[i].Op, p[i].Table, err)
+		}
+	}
+	return nil
+}
+
+// ExecTx runs all the writes in a single transaction
+func (p WritePlan) ExecTx(ctx context.Context, db TxBeginner, d Dialect) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := p.Exec(ctx, tx, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,110 @@
+package ncservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type planTestStruct struct {
+	EmId       int    `gorm:"column:em_id;primaryKey"`
+	Name       string `gorm:"column:em_name"`
+	TenantId   int    `gorm:"column:tm_id" fk:"tenant.tm_id"`
+	TenantName string `gorm:"column:tm_name" table:"tenant"`
+	VmEmId     int    `gorm:"column:em_id" table:"voicemail" fk:"extension.em_id"`
+	VmPin      string `gorm:"column:vm_pin" table:"voicemail"`
+	VmEmail    string `gorm:"column:vm_email" table:"voicemail"`
+}
+
+func TestPlanInsert(t *testing.T) {
+	x := &planTestStruct{EmId: 10, Name: "joe", TenantId: 3, TenantName: "acme", VmPin: "1234"}
+	plan, err := PlanInsert("extension", x, FilterNotNil)
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	assert.Equal(t, "tenant", plan[0].Table)
+	assert.Equal(t, "extension", plan[1].Table)
+	assert.Equal(t, "voicemail", plan[2].Table)
+
+	stmts, err := plan.Statements(MySQL)
+	require.NoError(t, err)
+	assert.Equal(t, "UPDATE `tenant` SET `tm_name` = ? WHERE `tm_id` = ?", stmts[0].SQL)
+	assert.Equal(t, []any{"acme", 3}, stmts[0].Args)
+	assert.Equal(t, "INSERT INTO `extension` (`em_id`, `em_name`, `tm_id`) VALUES (?, ?, ?)", stmts[1].SQL)
+	assert.Equal(t, []any{10, "joe", 3}, stmts[1].Args)
+	assert.Equal(t, "INSERT INTO `voicemail` (`em_id`, `vm_pin`, `vm_email`) VALUES (?, ?, ?)", stmts[2].SQL)
+	assert.Equal(t, []any{10, "1234", ""}, stmts[2].Args)
+}
+
+func TestPlanUpdate(t *testing.T) {
+	x := &planTestStruct{EmId: 10, Name: "joe", TenantId: 3, VmPin: "1234"}
+	plan, err := PlanUpdate("extension", x, FilterExclude([]string{"tm_name", "vm_email"}))
+	require.NoError(t, err)
+	stmts, err := plan.Statements(SQLServer)
+	require.NoError(t, err)
+	require.Len(t, stmts, 2)
+	assert.Equal(t, "UPDATE [extension] SET [em_name] = @p1, [tm_id] = @p2 WHERE [em_id] = @p3", stmts[0].SQL)
+	assert.Equal(t, []any{"joe", 3, 10}, stmts[0].Args)
+	assert.Equal(t, "UPDATE [voicemail] SET [vm_pin] = @p1 WHERE [em_id] = @p2", stmts[1].SQL)
+	assert.Equal(t, []any{"1234", 10}, stmts[1].Args)
+}
+
+func TestPlanReadOnly(t *testing.T) {
+	x := &struct {
+		EmId       int    `gorm:"column:em_id;primaryKey"`
+		Name       string `gorm:"column:em_name"`
+		Created    string `gorm:"column:em_created;->"`
+		TenantId   int    `gorm:"column:tm_id" fk:"tenant.tm_id"`
+		TenantName string `gorm:"column:tm_name;->" table:"tenant"`
+		VmEmId     int    `gorm:"column:em_id;<-:false" table:"voicemail" fk:"extension.em_id"`
+		VmPin      string `gorm:"column:vm_pin;-:all" table:"voicemail"`
+	}{EmId: 10, Name: "joe", TenantId: 3, TenantName: "acme", VmPin: "1234"}
+	for _, plan := range []func(string, any, ValueFilter) (WritePlan, error){PlanInsert, PlanUpdate} {
+		p, err := plan("extension", x, nil)
+		require.NoError(t, err)
+		require.Len(t, p, 1)
+		assert.Equal(t, "extension", p[0].Table)
+		for _, v := range p[0].Values {
+			assert.NotEqual(t, "em_created", v.Col)
+		}
+	}
+}
+
+func TestPlanNoJoin(t *testing.T) {
+	x := &struct {
+		ID    int    `gorm:"column:id;primaryKey"`
+		Other string `gorm:"column:other" table:"other"`
+	}{}
+	_, err := PlanInsert("main", x, nil)
+	assert.Error(t, err)
+}
+
+type recordingExecer struct {
+	queries []string
+	failOn  int
+}
+
+func (r *recordingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	r.queries = append(r.queries, query)
+	if len(r.queries) == r.failOn {
+		return nil, errors.New("boom")
+	}
+	return nil, nil
+}
+
+func TestPlanExec(t *testing.T) {
+	x := &planTestStruct{EmId: 10, Name: "joe", TenantId: 3, VmPin: "1234"}
+	plan, err := PlanUpdate("extension", x, nil)
+	require.NoError(t, err)
+
+	db := &recordingExecer{}
+	assert.NoError(t, plan.Exec(context.Background(), db, MySQL))
+	assert.Len(t, db.queries, 3)
+
+	db = &recordingExecer{failOn: 2}
+	err = plan.Exec(context.Background(), db, MySQL)
+	assert.EqualError(t, err, "update of extension failed. boom")
+}
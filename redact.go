@@ -0,0 +1,246 @@
+package ncservice
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// RedactPolicy is how a sensitive value is hidden
+type RedactPolicy string
+
+const (
+	// RedactFull replaces the value with [redacted]
+	RedactFull RedactPolicy = "full"
+	// RedactHmac replaces the value with a keyed fingerprint like [redacted 1f2e..]
+	// so callers can tell if a value changed without being able to guess it
+	RedactHmac RedactPolicy = "hmac"
+	// RedactMask keeps enough to recognize the value like j***@example.com or
+	// ******1234
+	RedactMask RedactPolicy = "mask"
+	// RedactDrop leaves the field out entirely
+	RedactDrop RedactPolicy = "drop"
+)
+
+// Redactor hides sensitive values of fields tagged `redact:"<policy>"`. Fields
+// with a tag set with SetTag, like `password:"true"`, use the policy for that
+// tag. It is safe for concurrent use.
+type Redactor struct {
+	mu  sync.RWMutex
+	key []byte
+	// tags are checked in order for fields that have no redact tag
+	tags []tagPolicy
+}
+
+type tagPolicy struct {
+	tag    string
+	policy RedactPolicy
+}
+
+// NewRedactor uses key for the HMAC fingerprints. Share the key between
+// services that compare fingerprints and keep it secret. Fields tagged
+// password or encrypt get RedactHmac.
+func NewRedactor(key []byte) *Redactor {
+	return &Redactor{
+		key:  key,
+		tags: []tagPolicy{{tag: "password", policy: RedactHmac}, {tag: "encrypt", policy: RedactHmac}},
+	}
+}
+
+// DefaultRedactor is used by HidePasswords. It has a random key so fingerprints
+// are only comparable within the process until SetKey is called.
+var DefaultRedactor = NewRedactor(randomKey())
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// SetKey replaces the key for HMAC fingerprints
+func (r *Redactor) SetKey(key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.key = key
+}
+
+// SetTag sets the policy of fields with a tag, like `pii:"true"`, that have no
+// redact tag. When a field has several of these tags the one set first wins.
+func (r *Redactor) SetTag(tag string, p RedactPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.tags {
+		if r.tags[i].tag == tag {
+			r.tags[i].policy = p
+			return
+		}
+	}
+	r.tags = append(r.tags, tagPolicy{tag: tag, policy: p})
+}
+
+// Policy of a field or empty if the field is not sensitive
+func (r *Redactor) Policy(fld reflect.StructField) RedactPolicy {
+	if p, exists := fld.Tag.Lookup("redact"); exists {
+		return RedactPolicy(p)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.tags {
+		if v, exists := fld.Tag.Lookup(t.tag); exists && v != "" && v != "false" {
+			return t.policy
+		}
+	}
+	return ""
+}
+
+// Redact is a ValueReader that hides the values of sensitive fields. Dropped
+// fields read as nil, use Filter to leave them out.
+func (r *Redactor) Redact(v any, fld reflect.StructField) (any, error) {
+	return r.redact(v, r.Policy(fld))
+}
+
+func (r *Redactor) redact(v any, policy RedactPolicy) (any, error) {
+	if policy == "" {
+		return v, nil
+	}
+	ref := reflect.ValueOf(v)
+	if ref.Kind() == reflect.Ptr {
+		if ref.IsNil() {
+			return nil, nil
+		}
+		v = ref.Elem().Interface()
+	}
+	if v == nil {
+		return nil, nil
+	}
+	s := fmt.Sprintf("%v", v)
+	switch policy {
+	case RedactFull:
+		return "[redacted]", nil
+	case RedactHmac:
+		r.mu.RLock()
+		mac := hmac.New(sha256.New, r.key)
+		r.mu.RUnlock()
+		mac.Write([]byte(s))
+		return "[redacted " + hex.EncodeToString(mac.Sum(nil)[:8]) + "]", nil
+	case RedactMask:
+		return mask(s), nil
+	case RedactDrop:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown redact policy '%s'", policy)
+}
+
+// mask keeps the first letter and domain of emails, the last 4 digits of phone
+// numbers and the last 4 characters of anything else longer than 8
+func mask(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		local := []rune(s[:at])
+		return string(local[0]) + strings.Repeat("*", len(local)-1) + s[at:]
+	}
+	if isPhone(s) {
+		runes := []rune(s)
+		keep := 4
+		for i := len(runes) - 1; i >= 0; i-- {
+			if !unicode.IsDigit(runes[i]) {
+				continue
+			}
+			if keep > 0 {
+				keep--
+				continue
+			}
+			runes[i] = '*'
+		}
+		return string(runes)
+	}
+	runes := []rune(s)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
+
+func isPhone(s string) bool {
+	digits := 0
+	for _, r := range s {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case !strings.ContainsRune("+-(). ", r):
+			return false
+		}
+	}
+	return digits >= 7
+}
+
+// Filter leaves out the fields with the drop policy
+func (r *Redactor) Filter() ValueFilter {
+	return func(_ Value, fld reflect.StructField) bool {
+		return r.Policy(fld) != RedactDrop
+	}
+}
+
+// RedactDiff hides the values in a diff of h, a struct or pointer to a struct,
+// made from ApiValues or Values. Changes to dropped fields are left out.
+func (r *Redactor) RedactDiff(h any, diff []DiffVal) ([]DiffVal, error) {
+	s := schemaOf(reflect.TypeOf(h))
+	redacted := make([]DiffVal, 0, len(diff))
+	for _, d := range diff {
+		f, found := s.byApi[d.Col]
+		if !found {
+			f, found = s.byColumn[d.Col]
+		}
+		if !found {
+			redacted = append(redacted, d)
+			continue
+		}
+		policy := r.Policy(f.StructField)
+		if policy == RedactDrop {
+			continue
+		}
+		var err error
+		if d.Orig, err = r.redact(d.Orig, policy); err != nil {
+			return nil, err
+		}
+		if d.Updated, err = r.redact(d.Updated, policy); err != nil {
+			return nil, err
+		}
+		redacted = append(redacted, d)
+	}
+	return redacted, nil
+}
+
+// LogValue logs the JSON fields of h with sensitive values hidden like
+// slog.Info("saved", "user", DefaultRedactor.LogValue(u))
+func (r *Redactor) LogValue(h any) slog.LogValuer {
+	return redactedLog{r: r, h: h}
+}
+
+type redactedLog struct {
+	r *Redactor
+	h any
+}
+
+func (l redactedLog) LogValue() slog.Value {
+	vals, err := ReadValues(l.h, ReadValueOptions{
+		Filter:       l.r.Filter(),
+		ColumnMapper: ApiColumns,
+		Prefixer:     ApiPrefix,
+		Reader:       l.r.Redact,
+	})
+	if err != nil {
+		return slog.StringValue("!ERROR " + err.Error())
+	}
+	attrs := make([]slog.Attr, len(vals))
+	for i, v := range vals {
+		attrs[i] = slog.Any(v.Col, v.Val)
+	}
+	return slog.GroupValue(attrs...)
+}
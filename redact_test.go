@@ -0,0 +1,103 @@
+package ncservice
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type redactTestStruct struct {
+	ID     int     `json:"id" gorm:"column:id;primaryKey"`
+	Pass   *string `json:"pass" gorm:"column:pass" password:"true"`
+	Token  string  `json:"token" gorm:"column:token" redact:"full"`
+	Email  string  `json:"email" gorm:"column:email" redact:"mask"`
+	Phone  string  `json:"phone" gorm:"column:phone" redact:"mask"`
+	Secret string  `json:"secret" gorm:"column:secret" redact:"drop"`
+}
+
+func TestRedactor(t *testing.T) {
+	x := &redactTestStruct{ID: 1, Pass: Ptr("abc123"), Token: "t0k3n", Email: "john.doe@example.com",
+		Phone: "+1 (555) 123-4567", Secret: "shh"}
+	r := NewRedactor([]byte("key"))
+	vals, err := ReadValues(x, ReadValueOptions{
+		Filter:       r.Filter(),
+		ColumnMapper: ApiColumns,
+		Prefixer:     ApiPrefix,
+		Reader:       r.Redact,
+	})
+	require.NoError(t, err)
+	require.Len(t, vals, 5)
+	assert.Equal(t, 1, vals[0].Val)
+	assert.Regexp(t, `^\[redacted [0-9a-f]{16}\]$`, vals[1].Val)
+	assert.Equal(t, "[redacted]", vals[2].Val)
+	assert.Equal(t, "j*******@example.com", vals[3].Val)
+	assert.Equal(t, "+* (***) ***-4567", vals[4].Val)
+
+	// fingerprints depend on the value and the key
+	same, _ := r.Redact("abc123", reflectField[redactTestStruct]("Pass"))
+	assert.Equal(t, vals[1].Val, same)
+	other, _ := r.Redact("abc124", reflectField[redactTestStruct]("Pass"))
+	assert.NotEqual(t, vals[1].Val, other)
+	otherKey, _ := NewRedactor([]byte("other")).Redact("abc123", reflectField[redactTestStruct]("Pass"))
+	assert.NotEqual(t, vals[1].Val, otherKey)
+
+	nothing, err := r.Redact((*string)(nil), reflectField[redactTestStruct]("Pass"))
+	require.NoError(t, err)
+	assert.Nil(t, nothing)
+}
+
+func reflectField[T any](name string) reflect.StructField {
+	var x T
+	f, _ := reflect.TypeOf(x).FieldByName(name)
+	return f
+}
+
+func TestRedactorTags(t *testing.T) {
+	type tagged struct {
+		Both  string `password:"true" pii:"true"`
+		Pii   string `pii:"true"`
+		Plain string `pii:"false"`
+	}
+	r := NewRedactor([]byte("key"))
+	assert.Equal(t, RedactPolicy(""), r.Policy(reflectField[tagged]("Pii")))
+	r.SetTag("pii", RedactMask)
+	for range 20 {
+		assert.Equal(t, RedactHmac, r.Policy(reflectField[tagged]("Both")))
+	}
+	assert.Equal(t, RedactMask, r.Policy(reflectField[tagged]("Pii")))
+	assert.Equal(t, RedactPolicy(""), r.Policy(reflectField[tagged]("Plain")))
+	r.SetTag("password", RedactFull)
+	assert.Equal(t, RedactFull, r.Policy(reflectField[tagged]("Both")))
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "a@x.com", mask("a@x.com"))
+	assert.Equal(t, "*****", mask("short"))
+	assert.Equal(t, "********7890", mask("abcd34567890"))
+	assert.Equal(t, "******4567", mask("5551234567"))
+}
+
+func TestRedactDiff(t *testing.T) {
+	r := NewRedactor([]byte("key"))
+	diff := DiffVals(
+		[]Value{{Col: "id", Val: 1}, {Col: "token", Val: "a"}, {Col: "secret", Val: "x"}},
+		[]Value{{Col: "id", Val: 2}, {Col: "token", Val: "b"}, {Col: "secret", Val: "y"}})
+	redacted, err := r.RedactDiff(&redactTestStruct{}, diff)
+	require.NoError(t, err)
+	assert.Equal(t, []DiffVal{{Col: "id", Orig: 1, Updated: 2}, {Col: "token", Orig: "[redacted]", Updated: "[redacted]"}}, redacted)
+}
+
+func TestRedactLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+	log.Info("saved", "user", NewRedactor([]byte("key")).LogValue(redactTestStruct{ID: 1, Token: "t0k3n", Secret: "shh"}))
+	out := buf.String()
+	assert.Contains(t, out, "user.id=1")
+	assert.Contains(t, out, "user.token=[redacted]")
+	assert.False(t, strings.Contains(out, "t0k3n") || strings.Contains(out, "shh"), out)
+}
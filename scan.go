@@ -0,0 +1,124 @@
+package ncservice
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Rows is satisfied by *sql.Rows and *sqlx.Rows
+type Rows interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// ScanOptions are optional
+type ScanOptions struct {
+	// Strict errors on columns that do not map to a field instead of skipping them
+	Strict     bool
+	Converters *Converters
+}
+
+// rowScanner maps the columns of a result to fields once for all rows
+type rowScanner struct {
+	fields []*fieldSchema
+	dest   []any
+	conv   *Converters
+}
+
+func newRowScanner(rows Rows, t reflect.Type, opts ScanOptions) (*rowScanner, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	s := schemaOf(t)
+	sc := &rowScanner{
+		fields: make([]*fieldSchema, len(cols)),
+		dest:   make([]any, len(cols)),
+		conv:   opts.Converters,
+	}
+	if sc.conv == nil {
+		sc.conv = DefaultConverters
+	}
+	for i, col := range cols {
+		f, found := s.lookupColumn(col)
+		if !found && opts.Strict {
+			return nil, fmt.Errorf("column %s has no field in %s", col, s.typ)
+		}
+		sc.fields[i] = f
+		sc.dest[i] = new(any)
+	}
+	return sc, nil
+}
+
+func (sc *rowScanner) scan(rows Rows, ref reflect.Value) error {
+	if err := rows.Scan(sc.dest...); err != nil {
+		return err
+	}
+	for i, f := range sc.fields {
+		if f == nil {
+			continue
+		}
+		val := *(sc.dest[i].(*any))
+		if val == nil {
+			// NULL clears fields of a struct that is reused
+			if field, valid := fieldByIndex(ref, f.index); valid {
+				field.Set(reflect.Zero(f.Type))
+			}
+			continue
+		}
+		v, err := decryptValue(f, Value{Table: f.table, Col: f.column, Val: val})
+		if err != nil {
+			return err
+		}
+		field, err := fieldByIndexAlloc(ref, f.index)
+		if err != nil {
+			return err
+		}
+		if err := setValue(field, f, v, sc.conv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanRow sets the fields of h, a pointer to a struct, from the current row
+// using the gorm column tags. Values are converted the same way as SetValues.
+// Columns can be qualified with a table like voicemail.vm_pin to set fields
+// tagged for that table in queries that join tables, any other table like
+// employee.em_id or an alias is the main table.
+func ScanRow(rows Rows, h any, opts ScanOptions) error {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return errors.New("ScanRow: target must be a pointer to a struct")
+	}
+	sc, err := newRowScanner(rows, ref.Type().Elem(), opts)
+	if err != nil {
+		return err
+	}
+	return sc.scan(rows, ref.Elem())
+}
+
+// ScanRows reads all remaining rows like ScanRow. Closing rows is left to
+// the caller.
+func ScanRows[T any](rows Rows, opts ScanOptions) ([]T, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("ScanRows: %s is not a struct", t)
+	}
+	sc, err := newRowScanner(rows, t, opts)
+	if err != nil {
+		return nil, err
+	}
+	var all []T
+	for rows.Next() {
+		var x T
+		if err := sc.scan(rows, reflect.ValueOf(&x).Elem()); err != nil {
+			return nil, err
+		}
+		all = append(all, x)
+	}
+	return all, rows.Err()
+}
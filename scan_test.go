@@ -0,0 +1,75 @@
+package ncservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScanRows returns driver values like database/sql does into *any
+type fakeScanRows struct {
+	cols []string
+	rows [][]any
+	pos  int
+}
+
+func (r *fakeScanRows) Columns() ([]string, error) { return r.cols, nil }
+func (r *fakeScanRows) Err() error                 { return nil }
+
+func (r *fakeScanRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.rows)
+}
+
+func (r *fakeScanRows) Scan(dest ...any) error {
+	for i, v := range r.rows[r.pos-1] {
+		*(dest[i].(*any)) = v
+	}
+	return nil
+}
+
+func TestScanRows(t *testing.T) {
+	rows := &fakeScanRows{
+		cols: []string{"em_id", "em_name", "tm_id", "voicemail.em_id", "vm_pin", "extra"},
+		rows: [][]any{
+			{int64(10), []byte("joe"), int64(3), int64(10), []byte("1234"), 1},
+			{int64(11), []byte("sue"), nil, nil, nil, 1},
+		},
+	}
+	all, err := ScanRows[planTestStruct](rows, ScanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []planTestStruct{
+		{EmId: 10, Name: "joe", TenantId: 3, VmEmId: 10, VmPin: "1234"},
+		{EmId: 11, Name: "sue"},
+	}, all)
+
+	rows.pos = 0
+	_, err = ScanRows[planTestStruct](rows, ScanOptions{Strict: true})
+	assert.EqualError(t, err, "column extra has no field in ncservice.planTestStruct")
+
+	// reused structs are cleared by NULLs
+	rows = &fakeScanRows{cols: rows.cols[:5], rows: [][]any{{int64(11), "sue", nil, nil, nil}}}
+	require.True(t, rows.Next())
+	x := planTestStruct{TenantId: 3, VmPin: "1"}
+	require.NoError(t, ScanRow(rows, &x, ScanOptions{Strict: true}))
+	assert.Equal(t, planTestStruct{EmId: 11, Name: "sue"}, x)
+	assert.Error(t, ScanRow(rows, x, ScanOptions{}))
+}
+
+func TestScanQualified(t *testing.T) {
+	rows := &fakeScanRows{
+		cols: []string{"extension.em_id", "e.EM_NAME", "voicemail.em_id", "tenant.tm_name"},
+		rows: [][]any{{int64(10), "joe", int64(11), "acme"}},
+	}
+	all, err := ScanRows[planTestStruct](rows, ScanOptions{Strict: true})
+	require.NoError(t, err)
+	assert.Equal(t, []planTestStruct{{EmId: 10, Name: "joe", VmEmId: 11, TenantName: "acme"}}, all)
+
+	rows = &fakeScanRows{cols: []string{"e.nope", "tenant.em_name"}}
+	_, err = ScanRows[planTestStruct](rows, ScanOptions{Strict: true})
+	assert.EqualError(t, err, "column e.nope has no field in ncservice.planTestStruct")
+	rows.cols = rows.cols[1:]
+	_, err = ScanRows[planTestStruct](rows, ScanOptions{Strict: true})
+	assert.EqualError(t, err, "column tenant.em_name has no field in ncservice.planTestStruct")
+}
@@ -0,0 +1,270 @@
+package ncservice
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fieldSchema is a leaf field of a struct with the tags this library cares about
+// already parsed
+type fieldSchema struct {
+	structField
+	pos       int
+	gorm      string
+	column    string // includes any embedded prefix
+	hasColumn bool
+	json      string // dotted path for fields in nested structs
+	hasJson   bool
+	table     string
+	key       bool
+	password  bool
+	encrypt   bool
+	enum      []string
+	fk        string
+	show      string
+	rules     fieldRules
+}
+
+// schema is the field metadata of a struct type. It is built once per type and
+// is read-only after that so it is safe to share between goroutines.
+type schema struct {
+	typ      reflect.Type
+	fields   []*fieldSchema
+	byColumn map[string]*fieldSchema
+	byApi    map[string]*fieldSchema
+	byJson   map[string]*fieldSchema // keyed by lower case json name
+	keys     []*fieldSchema
+}
+
+var schemas sync.Map // reflect.Type -> *schema
+
+// schemaOf returns the cached schema for a struct type or pointer to a struct type
+func schemaOf(t reflect.Type) *schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if s, found := schemas.Load(t); found {
+		return s.(*schema)
+	}
+	s, _ := schemas.LoadOrStore(t, newSchema(t))
+	return s.(*schema)
+}
+
+func newSchema(t reflect.Type) *schema {
+	s := &schema{
+		typ:      t,
+		byColumn: make(map[string]*fieldSchema),
+		byApi:    make(map[string]*fieldSchema),
+		byJson:   make(map[string]*fieldSchema),
+	}
+	for i, sf := range structFields(t) {
+		f := &fieldSchema{
+			structField: sf,
+			pos:         i,
+			gorm:        sf.Tag.Get("gorm"),
+			table:       sf.Tag.Get("table"),
+			password:    sf.Tag.Get("password") != "",
+			encrypt:     sf.Tag.Get("encrypt") != "" && sf.Tag.Get("encrypt") != "false",
+			fk:          sf.Tag.Get("fk"),
+			show:        sf.Tag.Get("show"),
+		}
+		if col, exists := getGormTag(f.gorm, "column"); exists {
+			f.column = sf.prefix(DatabasePrefix) + col
+			f.hasColumn = true
+			if _, exists := s.byColumn[f.column]; !exists {
+				s.byColumn[f.column] = f
+			}
+			if _, f.key = getGormTag(f.gorm, "primaryKey"); f.key {
+				s.keys = append(s.keys, f)
+			}
+		}
+		if name, exists := ApiColumns(sf.StructField); exists && !sf.jsonHidden() {
+			f.json = sf.prefix(ApiPrefix) + name
+			f.hasJson = true
+			if _, exists := s.byApi[f.json]; !exists {
+				s.byApi[f.json] = f
+			}
+			lc := strings.ToLower(f.json)
+			if _, exists := s.byJson[lc]; !exists {
+				s.byJson[lc] = f
+			}
+		}
+		if enum := sf.Tag.Get("enum"); enum != "" {
+			f.enum = strings.Split(enum, ",")
+		}
+		f.rules = parseRules(sf.StructField, f.enum)
+		s.fields = append(s.fields, f)
+	}
+	return s
+}
+
+// hasTable tells if a field is tagged for the table, the main table of a
+// struct is never named in a table tag
+func (s *schema) hasTable(table string) bool {
+	return slices.ContainsFunc(s.fields, func(f *fieldSchema) bool { return f.table == table })
+}
+
+// readOnly tells if gorm never writes the field, either ignored with - or
+// read only with -> which is how fields joined from other tables are generated
+func (f *fieldSchema) readOnly() bool {
+	return gormReadOnly(f.gorm)
+}
+
+func gormReadOnly(tag string) bool {
+	if gormIgnored(tag) {
+		return true
+	}
+	if write, exists := getGormTag(tag, "<-"); exists {
+		return write == "false"
+	}
+	_, readOnly := getGormTag(tag, "->")
+	return readOnly
+}
+
+// gormIgnored tells if gorm neither reads nor writes the field
+func gormIgnored(tag string) bool {
+	ignored, exists := getGormTag(tag, "-")
+	return exists && ignored != "migration"
+}
+
+var databaseColumnsFn = reflect.ValueOf(DatabaseColumns).Pointer()
+var apiColumnsFn = reflect.ValueOf(ApiColumns).Pointer()
+var databasePrefixFn = reflect.ValueOf(DatabasePrefix).Pointer()
+var apiPrefixFn = reflect.ValueOf(ApiPrefix).Pointer()
+
+type mapping int
+
+const (
+	customMapping mapping = iota
+	databaseMapping
+	apiMapping
+)
+
+// mappingOf recognizes the standard mappers so the pre-parsed tags can be used
+// instead of calling the mapper on every field
+func mappingOf(getCol ColumnMapper, getPrefix ColumnPrefixer) mapping {
+	colFn := reflect.ValueOf(getCol).Pointer()
+	prefixFn := uintptr(0)
+	if getPrefix != nil {
+		prefixFn = reflect.ValueOf(getPrefix).Pointer()
+	}
+	switch {
+	case colFn == databaseColumnsFn && prefixFn == databasePrefixFn:
+		return databaseMapping
+	case colFn == apiColumnsFn && prefixFn == apiPrefixFn:
+		return apiMapping
+	}
+	return customMapping
+}
+
+// columnResolver returns a function that gives the column of a field
+func columnResolver(getCol ColumnMapper, getPrefix ColumnPrefixer) func(f *fieldSchema) (string, bool) {
+	switch mappingOf(getCol, getPrefix) {
+	case databaseMapping:
+		return func(f *fieldSchema) (string, bool) {
+			return f.column, f.hasColumn
+		}
+	case apiMapping:
+		return func(f *fieldSchema) (string, bool) {
+			return f.json, f.hasJson
+		}
+	}
+	return func(f *fieldSchema) (string, bool) {
+		col, exists := getCol(f.StructField)
+		if !exists {
+			return "", false
+		}
+		return f.prefix(getPrefix) + col, true
+	}
+}
+
+// columnIndex maps fields by their column for the given mapper
+func (s *schema) columnIndex(getCol ColumnMapper, getPrefix ColumnPrefixer) map[string]*fieldSchema {
+	switch mappingOf(getCol, getPrefix) {
+	case databaseMapping:
+		return s.byColumn
+	case apiMapping:
+		return s.byApi
+	}
+	column := columnResolver(getCol, getPrefix)
+	index := make(map[string]*fieldSchema, len(s.fields))
+	for _, f := range s.fields {
+		if col, exists := column(f); exists {
+			if _, dup := index[col]; !dup {
+				index[col] = f
+			}
+		}
+	}
+	return index
+}
+
+// fieldOfJson finds the field of a JSON name, case insensitive. Fields inside
+// nested structs are addressed with a dotted path like address.city.
+func (s *schema) fieldOfJson(jsonField string) (*fieldSchema, error) {
+	f, found := s.byJson[strings.ToLower(jsonField)]
+	if !found {
+		return nil, fmt.Errorf("unknown field: %s", jsonField)
+	}
+	if f.gorm == "" {
+		return nil, fmt.Errorf("field %s has no gorm tag", jsonField)
+	}
+	if !f.hasColumn {
+		return nil, fmt.Errorf("field %s has no column in gorm tag", jsonField)
+	}
+	return f, nil
+}
+
+// fieldOfColumn finds the field of a column that also has a JSON name
+func (s *schema) fieldOfColumn(column string) (*fieldSchema, error) {
+	f, found := s.lookupColumn(column)
+	if !found {
+		return nil, fmt.Errorf("unknown column: %s", column)
+	}
+	if !f.hasJson {
+		return nil, fmt.Errorf("column %s has no json field", column)
+	}
+	return f, nil
+}
+
+// lookupColumn finds the field of a column. The column can be qualified with a
+// table like voicemail.em_id to find fields tagged for another table. Any other
+// qualifier is taken as the main table, or its alias, like e.em_id. Columns
+// are matched case insensitive when there is no exact match.
+func (s *schema) lookupColumn(column string) (*fieldSchema, bool) {
+	if f, found := s.byColumn[column]; found {
+		return f, true
+	}
+	for _, candidate := range s.fields {
+		if candidate.hasColumn && (strings.EqualFold(candidate.column, column) ||
+			(candidate.table != "" && strings.EqualFold(candidate.table+"."+candidate.column, column))) {
+			return candidate, true
+		}
+	}
+	table, col, qualified := strings.Cut(column, ".")
+	if !qualified || slices.ContainsFunc(s.fields, func(f *fieldSchema) bool { return strings.EqualFold(f.table, table) }) {
+		return nil, false
+	}
+	for _, candidate := range s.fields {
+		if candidate.hasColumn && candidate.table == "" && strings.EqualFold(candidate.column, col) {
+			return candidate, true
+		}
+	}
+	return nil, false
+}
+
+func (s *schema) mappings() []FieldMapping {
+	var mappings []FieldMapping
+	for _, f := range s.fields {
+		if f.hasColumn && f.hasJson {
+			mappings = append(mappings, FieldMapping{
+				Field:  f.json,
+				Column: f.column,
+				Table:  f.table,
+			})
+		}
+	}
+	return mappings
+}
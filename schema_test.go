@@ -0,0 +1,253 @@
+package ncservice
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestStruct struct {
+	EmId     int     `json:"emId" gorm:"column:em_id;primaryKey"`
+	Name     string  `json:"name" gorm:"column:em_name"`
+	Number   int     `json:"number" gorm:"column:em_number"`
+	Email    *string `json:"email" gorm:"column:em_email"`
+	TenantId int     `json:"tenantId" gorm:"column:tm_id" fk:"tenant.tm_id"`
+	Status   string  `json:"status" gorm:"column:em_status" enum:"active,inactive"`
+	Password string  `json:"password" gorm:"column:em_password" password:"true"`
+	Notes    string  `json:"notes" gorm:"column:em_notes" show:"full"`
+	Other    string  `json:"other" gorm:"column:other_col" table:"another_table"`
+	Ignore   string
+}
+
+func TestSchema(t *testing.T) {
+	s := schemaOf(reflect.TypeOf(&schemaTestStruct{}))
+	assert.Same(t, s, schemaOf(reflect.TypeOf(schemaTestStruct{})))
+	assert.Len(t, s.fields, 10)
+	assert.Len(t, s.keys, 1)
+	assert.Equal(t, "em_id", s.keys[0].column)
+
+	tenant := s.byJson["tenantid"]
+	assert.Equal(t, "tm_id", tenant.column)
+	assert.Equal(t, "tenant.tm_id", tenant.fk)
+	assert.Equal(t, []string{"active", "inactive"}, s.byColumn["em_status"].enum)
+	assert.True(t, s.byApi["password"].password)
+	assert.Equal(t, "full", s.byApi["notes"].show)
+	assert.Equal(t, "another_table", s.byApi["other"].table)
+	assert.False(t, s.fields[9].hasColumn)
+	assert.False(t, s.fields[9].hasJson)
+}
+
+func TestSchemaConcurrent(t *testing.T) {
+	type concurrent struct {
+		ID   int    `gorm:"column:id;primaryKey"`
+		Name string `gorm:"column:name"`
+	}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var x concurrent
+			assert.NoError(t, SetValues(&x, []Value{{Col: "id", Val: i}, {Col: "name", Val: "n"}}))
+			vals, err := Values(&x, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, i, vals[0].Val)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCustomMapper(t *testing.T) {
+	x := schemaTestStruct{EmId: 1, Name: "joe"}
+	upper := func(fld reflect.StructField) (string, bool) {
+		return strings.ToUpper(fld.Name), true
+	}
+	vals, err := ReadValues(&x, ReadValueOptions{ColumnMapper: upper})
+	assert.NoError(t, err)
+	assert.Equal(t, "EMID", vals[0].Col)
+
+	var copy schemaTestStruct
+	assert.NoError(t, setValues(&copy, vals, upper, nil, DefaultConverters))
+	assert.Equal(t, x, copy)
+}
+
+func benchmarkStruct() *schemaTestStruct {
+	return &schemaTestStruct{
+		EmId:     1,
+		Name:     "Alice",
+		Number:   100,
+		Email:    Ptr("alice@example.com"),
+		TenantId: 3,
+		Status:   "active",
+	}
+}
+
+func BenchmarkReadValues(b *testing.B) {
+	x := benchmarkStruct()
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := Values(x, FilterNotNil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := uncachedReadValues(x, FilterNotNil, DatabaseColumns); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSetValues(b *testing.B) {
+	vals, _ := Values(benchmarkStruct(), nil)
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			var x schemaTestStruct
+			if err := SetValues(&x, vals); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			var x schemaTestStruct
+			if err := uncachedSetValues(&x, vals, DatabaseColumns); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFieldToColumn(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			if _, _, err := FieldToColumn[schemaTestStruct]("status"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := uncachedFieldToColumn[schemaTestStruct]("status"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFilterKeys(b *testing.B) {
+	x := benchmarkStruct()
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			FilterOnlyKeys(x)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			uncachedKeys(x)
+		}
+	})
+}
+
+// The uncached implementations are how values were read before the schema cache
+// and are kept here only to compare against
+
+func uncachedReadValues(h any, f ValueFilter, getCol ColumnMapper) ([]Value, error) {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() == reflect.Ptr {
+		ref = ref.Elem()
+	}
+	t := ref.Type()
+	var values []Value
+	for i := range ref.NumField() {
+		fld := t.Field(i)
+		col, exists := getCol(fld)
+		if !exists {
+			continue
+		}
+		v := Value{
+			Col:   col,
+			Table: fld.Tag.Get("table"),
+		}
+		refval := ref.Field(i)
+		if !(refval.Kind() == reflect.Ptr && refval.IsNil()) {
+			v.Val = refval.Interface()
+		}
+		if f == nil || f(v, fld) {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+func uncachedSetValues(h any, values []Value, getCol ColumnMapper) error {
+	ref := reflect.ValueOf(h).Elem()
+	t := ref.Type()
+	for i := range ref.NumField() {
+		col, exists := getCol(t.Field(i))
+		if !exists {
+			continue
+		}
+		for _, v := range values {
+			if v.Col == col {
+				if err := uncachedSetValue(ref.Field(i), v); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func uncachedFieldToColumn[T any](jsonField string) (string, error) {
+	var instance T
+	t := reflect.TypeOf(instance)
+	for i := range t.NumField() {
+		fld := t.Field(i)
+		jsonTag := fld.Tag.Get("json")
+		if strings.EqualFold(strings.Split(jsonTag, ",")[0], jsonField) {
+			col, _ := getGormTag(fld.Tag.Get("gorm"), "column")
+			return col, nil
+		}
+	}
+	return "", fmt.Errorf("unknown field: %s", jsonField)
+}
+
+func uncachedKeys(x any) []string {
+	ref := reflect.ValueOf(x).Elem()
+	t := ref.Type()
+	var keys []string
+	for i := range ref.NumField() {
+		tag := t.Field(i).Tag.Get("gorm")
+		if _, exists := getGormTag(tag, "primaryKey"); exists {
+			col, _ := getGormTag(tag, "column")
+			keys = append(keys, col)
+		}
+	}
+	return keys
+}
+
+func uncachedSetValue(to reflect.Value, from Value) error {
+	fromVal := reflect.ValueOf(from.Val)
+	if !fromVal.IsValid() {
+		return nil
+	}
+	if fromVal.Type().ConvertibleTo(to.Type()) {
+		to.Set(fromVal.Convert(to.Type()))
+		return nil
+	}
+	if to.Kind() == reflect.Ptr && fromVal.Type().ConvertibleTo(to.Type().Elem()) {
+		pfield := reflect.New(to.Type().Elem())
+		pfield.Elem().Set(fromVal.Convert(to.Type().Elem()))
+		to.Set(pfield)
+		return nil
+	}
+	return fmt.Errorf("type not convertible: %s", fromVal.Type())
+}
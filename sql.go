@@ -0,0 +1,358 @@
+package ncservice
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the flavor of SQL to generate for a given database driver
+type Dialect int
+
+const (
+	MySQL Dialect = iota
+	SQLServer
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case MySQL:
+		return "mysql"
+	case SQLServer:
+		return "sqlserver"
+	}
+	return "unknown"
+}
+
+// Quote an identifier like a table or a column name. Names with a schema
+// like dbo.Users have each part quoted.
+func (d Dialect) Quote(ident string) string {
+	parts := strings.Split(ident, ".")
+	for i, p := range parts {
+		switch d {
+		case SQLServer:
+			parts[i] = "[" + strings.ReplaceAll(p, "]", "]]") + "]"
+		default:
+			parts[i] = "`" + strings.ReplaceAll(p, "`", "``") + "`"
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Placeholder for the nth parameter of a statement starting at 1
+func (d Dialect) Placeholder(n int) string {
+	switch d {
+	case SQLServer:
+		return "@p" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Statement is parameterized SQL ready to be handed to a database driver
+type Statement struct {
+	SQL  string
+	Args []any
+}
+
+// sqlBuilder accumulates SQL text and keeps the placeholders numbered in the
+// order arguments are added
+type sqlBuilder struct {
+	d    Dialect
+	sql  strings.Builder
+	args []any
+}
+
+func (b *sqlBuilder) write(s ...string) {
+	for _, x := range s {
+		b.sql.WriteString(x)
+	}
+}
+
+func (b *sqlBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return b.d.Placeholder(len(b.args))
+}
+
+func (b *sqlBuilder) statement() Statement {
+	return Statement{SQL: b.sql.String(), Args: b.args}
+}
+
+// where writes a clause matching all the given values, typically the keys
+func (b *sqlBuilder) where(keys []Value) {
+	b.write(" WHERE ")
+	b.conditions(keys)
+}
+
+func (b *sqlBuilder) conditions(keys []Value) {
+	for i, k := range keys {
+		if i > 0 {
+			b.write(" AND ")
+		}
+		b.write(b.d.Quote(k.Col), " = ", b.arg(k.Val))
+	}
+}
+
+var errNoKeys = errors.New("no primary key columns")
+
+// Insert builds an INSERT of all the fields of h that pass the filter. Fields
+// that are tagged for another table are not included, and when table is one
+// named by a table tag only the fields tagged for it are.
+func (d Dialect) Insert(table string, h any, f ValueFilter) (Statement, error) {
+	vals, err := writeValues(table, h, f)
+	if err != nil {
+		return Statement{}, err
+	}
+	return d.insert(table, vals)
+}
+
+func (d Dialect) insert(table string, vals []Value) (Statement, error) {
+	if len(vals) == 0 {
+		return Statement{}, fmt.Errorf("insert into %s has no columns", table)
+	}
+	b := &sqlBuilder{d: d}
+	b.write("INSERT INTO ", d.Quote(table), " (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(v.Col))
+	}
+	b.write(") VALUES (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(b.arg(v.Val))
+	}
+	b.write(")")
+	return b.statement(), nil
+}
+
+// Update builds an UPDATE of all the non-key fields of h that pass the filter
+// for the row identified by the primary key of h.
+func (d Dialect) Update(table string, h any, f ValueFilter) (Statement, error) {
+	keys, err := rowKeys(h)
+	if err != nil {
+		return Statement{}, err
+	}
+	vals, err := writeValues(table, h, FilterAnd(FilterNoKeys(h), filterOrAll(f)))
+	if err != nil {
+		return Statement{}, err
+	}
+	return d.update(table, vals, keys)
+}
+
+func (d Dialect) update(table string, vals []Value, keys []Value) (Statement, error) {
+	if len(vals) == 0 {
+		return Statement{}, fmt.Errorf("update of %s has no columns", table)
+	}
+	b := &sqlBuilder{d: d}
+	b.write("UPDATE ", d.Quote(table), " SET ")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(v.Col), " = ", b.arg(v.Val))
+	}
+	b.where(keys)
+	return b.statement(), nil
+}
+
+// Upsert builds a statement that inserts h or, when a row with the same primary
+// key exists, updates it. All the fields of h are inserted but only those that
+// pass the filter are updated, keys are never updated. MySQL uses INSERT ... ON
+// DUPLICATE KEY UPDATE and SQL Server uses MERGE.
+func (d Dialect) Upsert(table string, h any, f ValueFilter) (Statement, error) {
+	keys := GetPrimaryKeyColumn(h)
+	if len(keys) == 0 {
+		return Statement{}, errNoKeys
+	}
+	vals, err := writeValues(table, h, nil)
+	if err != nil {
+		return Statement{}, err
+	}
+	for _, k := range keys {
+		if !slices.ContainsFunc(vals, func(v Value) bool { return v.Col == k }) {
+			return Statement{}, fmt.Errorf("upsert into %s is missing key %s", table, k)
+		}
+	}
+	updates, err := writeValues(table, h, FilterAnd(FilterNoKeys(h), filterOrAll(f)))
+	if err != nil {
+		return Statement{}, err
+	}
+	if d == SQLServer {
+		return d.merge(table, vals, keys, updates), nil
+	}
+	stmt, err := d.insert(table, vals)
+	if err != nil {
+		return Statement{}, err
+	}
+	var b strings.Builder
+	b.WriteString(stmt.SQL)
+	b.WriteString(" ON DUPLICATE KEY UPDATE ")
+	if len(updates) == 0 {
+		// nothing to update but the statement still has to succeed
+		b.WriteString(d.Quote(keys[0]) + " = " + d.Quote(keys[0]))
+	}
+	for i, v := range updates {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		col := d.Quote(v.Col)
+		b.WriteString(col + " = VALUES(" + col + ")")
+	}
+	stmt.SQL = b.String()
+	return stmt, nil
+}
+
+func (d Dialect) merge(table string, vals []Value, keys []string, updates []Value) Statement {
+	b := &sqlBuilder{d: d}
+	b.write("MERGE INTO ", d.Quote(table), " AS target USING (VALUES (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(b.arg(v.Val))
+	}
+	b.write(")) AS source (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(v.Col))
+	}
+	b.write(") ON ")
+	for i, k := range keys {
+		if i > 0 {
+			b.write(" AND ")
+		}
+		b.write("target.", d.Quote(k), " = source.", d.Quote(k))
+	}
+	if len(updates) > 0 {
+		b.write(" WHEN MATCHED THEN UPDATE SET ")
+		for i, v := range updates {
+			if i > 0 {
+				b.write(", ")
+			}
+			b.write("target.", d.Quote(v.Col), " = source.", d.Quote(v.Col))
+		}
+	}
+	b.write(" WHEN NOT MATCHED THEN INSERT (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(v.Col))
+	}
+	b.write(") VALUES (")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write("source.", d.Quote(v.Col))
+	}
+	// MERGE has to be terminated
+	b.write(");")
+	return b.statement()
+}
+
+// Delete builds a DELETE of the row identified by the primary key of h
+func (d Dialect) Delete(table string, h any) (Statement, error) {
+	keys, err := rowKeys(h)
+	if err != nil {
+		return Statement{}, err
+	}
+	b := &sqlBuilder{d: d}
+	b.write("DELETE FROM ", d.Quote(table))
+	b.where(keys)
+	return b.statement(), nil
+}
+
+// Select builds a SELECT of all the fields of h that pass the filter for the row
+// identified by the primary key of h.
+func (d Dialect) Select(table string, h any, f ValueFilter) (Statement, error) {
+	keys, err := rowKeys(h)
+	if err != nil {
+		return Statement{}, err
+	}
+	vals, err := tableValues(table, h, FilterAnd(filterOrAll(f), func(_ Value, fld reflect.StructField) bool {
+		return !gormIgnored(fld.Tag.Get("gorm"))
+	}))
+	if err != nil {
+		return Statement{}, err
+	}
+	if len(vals) == 0 {
+		return Statement{}, fmt.Errorf("select from %s has no columns", table)
+	}
+	b := &sqlBuilder{d: d}
+	b.write("SELECT ")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(d.Quote(v.Col))
+	}
+	b.write(" FROM ", d.Quote(table))
+	b.where(keys)
+	return b.statement(), nil
+}
+
+// tableValues are the database values of h that belong in the given table. Fields
+// with a table tag naming another table are left out. Fields without a table
+// tag belong to the main table of h so they are also left out when table is
+// one named by a table tag.
+func tableValues(table string, h any, f ValueFilter) ([]Value, error) {
+	main := !schemaOf(baseType(reflect.TypeOf(h))).hasTable(table)
+	return Values(h, FilterAnd(filterOrAll(f), func(v Value, _ reflect.StructField) bool {
+		return v.Table == table || (v.Table == "" && main)
+	}))
+}
+
+// writeValues are the tableValues of h that gorm would write, fields tagged
+// read only with -> or ignored with - are left out. Values of password fields
+// are hashed and values of encrypted fields are encrypted.
+func writeValues(table string, h any, f ValueFilter) ([]Value, error) {
+	main := !schemaOf(baseType(reflect.TypeOf(h))).hasTable(table)
+	vals, fields, err := readFieldValues(h, ReadValueOptions{
+		Filter: FilterAnd(filterOrAll(f), func(v Value, fld reflect.StructField) bool {
+			return (v.Table == table || (v.Table == "" && main)) && !gormReadOnly(fld.Tag.Get("gorm"))
+		}),
+		ColumnMapper: DatabaseColumns,
+		Prefixer:     DatabasePrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, fld := range fields {
+		if vals[i].Val, err = protectValue(fld, vals[i].Val); err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
+
+// protectValue is what is stored for the value of a field being written, the
+// hash of password fields and the ciphertext of encrypted fields. It is applied
+// after filtering so only values that are written are hashed or encrypted.
+func protectValue(f *fieldSchema, v any) (any, error) {
+	var err error
+	if f.password && v != nil {
+		if v, err = DefaultPasswordHasher.HashPasswords(v, f.StructField); err != nil {
+			return nil, err
+		}
+	}
+	if f.encrypt && v != nil {
+		return DefaultEncryptor.EncryptValues(v, f.StructField)
+	}
+	return v, nil
+}
+
+func filterOrAll(f ValueFilter) ValueFilter {
+	if f == nil {
+		return FilterAll
+	}
+	return f
+}
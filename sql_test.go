@@ -0,0 +1,171 @@
+package ncservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sqlTestStruct struct {
+	ID     int     `gorm:"column:id;primaryKey"`
+	Name   string  `gorm:"column:name"`
+	Email  *string `gorm:"column:email"`
+	Status string  `gorm:"column:status"`
+	Other  string  `gorm:"column:other" table:"other_table"`
+	Ignore string
+}
+
+func TestStatements(t *testing.T) {
+	x := &sqlTestStruct{ID: 7, Name: "joe", Status: "active", Other: "x"}
+	tests := []struct {
+		name      string
+		build     func(d Dialect) (Statement, error)
+		mysql     string
+		sqlserver string
+		args      []any
+	}{
+		{
+			name: "insert",
+			build: func(d Dialect) (Statement, error) {
+				return d.Insert("users", x, FilterNotNil)
+			},
+			mysql:     "INSERT INTO `users` (`id`, `name`, `status`) VALUES (?, ?, ?)",
+			sqlserver: "INSERT INTO [users] ([id], [name], [status]) VALUES (@p1, @p2, @p3)",
+			args:      []any{7, "joe", "active"},
+		},
+		{
+			name: "update",
+			build: func(d Dialect) (Statement, error) {
+				return d.Update("users", x, nil)
+			},
+			mysql:     "UPDATE `users` SET `name` = ?, `email` = ?, `status` = ? WHERE `id` = ?",
+			sqlserver: "UPDATE [users] SET [name] = @p1, [email] = @p2, [status] = @p3 WHERE [id] = @p4",
+			args:      []any{"joe", nil, "active", 7},
+		},
+		{
+			name: "delete",
+			build: func(d Dialect) (Statement, error) {
+				return d.Delete("dbo.users", x)
+			},
+			mysql:     "DELETE FROM `dbo`.`users` WHERE `id` = ?",
+			sqlserver: "DELETE FROM [dbo].[users] WHERE [id] = @p1",
+			args:      []any{7},
+		},
+		{
+			name: "select",
+			build: func(d Dialect) (Statement, error) {
+				return d.Select("users", x, FilterExclude([]string{"email"}))
+			},
+			mysql:     "SELECT `id`, `name`, `status` FROM `users` WHERE `id` = ?",
+			sqlserver: "SELECT [id], [name], [status] FROM [users] WHERE [id] = @p1",
+			args:      []any{7},
+		},
+		{
+			name: "other table",
+			build: func(d Dialect) (Statement, error) {
+				return d.Update("other_table", x, FilterInclude([]string{"other"}))
+			},
+			mysql:     "UPDATE `other_table` SET `other` = ? WHERE `id` = ?",
+			sqlserver: "UPDATE [other_table] SET [other] = @p1 WHERE [id] = @p2",
+			args:      []any{"x", 7},
+		},
+		{
+			name: "upsert",
+			build: func(d Dialect) (Statement, error) {
+				return d.Upsert("users", x, FilterExclude([]string{"status"}))
+			},
+			mysql: "INSERT INTO `users` (`id`, `name`, `email`, `status`) VALUES (?, ?, ?, ?)" +
+				" ON DUPLICATE KEY UPDATE `name` = VALUES(`name`), `email` = VALUES(`email`)",
+			sqlserver: "MERGE INTO [users] AS target USING (VALUES (@p1, @p2, @p3, @p4))" +
+				" AS source ([id], [name], [email], [status]) ON target.[id] = source.[id]" +
+				" WHEN MATCHED THEN UPDATE SET target.[name] = source.[name], target.[email] = source.[email]" +
+				" WHEN NOT MATCHED THEN INSERT ([id], [name], [email], [status])" +
+				" VALUES (source.[id], source.[name], source.[email], source.[status]);",
+			args: []any{7, "joe", nil, "active"},
+		},
+		{
+			name: "upsert only insert",
+			build: func(d Dialect) (Statement, error) {
+				return d.Upsert("users", x, FilterOnlyKeys(x))
+			},
+			mysql: "INSERT INTO `users` (`id`, `name`, `email`, `status`) VALUES (?, ?, ?, ?)" +
+				" ON DUPLICATE KEY UPDATE `id` = `id`",
+			sqlserver: "MERGE INTO [users] AS target USING (VALUES (@p1, @p2, @p3, @p4))" +
+				" AS source ([id], [name], [email], [status]) ON target.[id] = source.[id]" +
+				" WHEN NOT MATCHED THEN INSERT ([id], [name], [email], [status])" +
+				" VALUES (source.[id], source.[name], source.[email], source.[status]);",
+			args: []any{7, "joe", nil, "active"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmt, err := test.build(MySQL)
+			assert.NoError(t, err)
+			assert.Equal(t, test.mysql, stmt.SQL)
+			assert.Equal(t, test.args, stmt.Args)
+
+			stmt, err = test.build(SQLServer)
+			assert.NoError(t, err)
+			assert.Equal(t, test.sqlserver, stmt.SQL)
+			assert.Equal(t, test.args, stmt.Args)
+		})
+	}
+}
+
+func TestOtherTableStatements(t *testing.T) {
+	x := &sqlTestStruct{ID: 7, Name: "joe", Status: "active", Other: "x"}
+	stmt, err := MySQL.Insert("other_table", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `other_table` (`other`) VALUES (?)", stmt.SQL)
+	assert.Equal(t, []any{"x"}, stmt.Args)
+
+	stmt, err = MySQL.Update("other_table", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `other_table` SET `other` = ? WHERE `id` = ?", stmt.SQL)
+
+	_, err = MySQL.Upsert("other_table", x, nil)
+	assert.EqualError(t, err, "upsert into other_table is missing key id")
+}
+
+func TestReadOnlyStatements(t *testing.T) {
+	x := &struct {
+		ID      int    `gorm:"column:id;primaryKey"`
+		Name    string `gorm:"column:name"`
+		Created string `gorm:"column:created;->"`
+		Cache   string `gorm:"column:cache;-:all"`
+		Audit   string `gorm:"column:audit;<-:false"`
+	}{ID: 1, Name: "x"}
+	stmt, err := MySQL.Insert("t", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `t` (`id`, `name`) VALUES (?, ?)", stmt.SQL)
+	stmt, err = MySQL.Update("t", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `t` SET `name` = ? WHERE `id` = ?", stmt.SQL)
+	stmt, err = MySQL.Upsert("t", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `t` (`id`, `name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)", stmt.SQL)
+
+	// read only columns can still be read
+	stmt, err = MySQL.Select("t", x, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `id`, `name`, `created`, `audit` FROM `t` WHERE `id` = ?", stmt.SQL)
+}
+
+func TestStatementErrors(t *testing.T) {
+	noKeys := &struct {
+		Name string `gorm:"column:name"`
+	}{}
+	_, err := MySQL.Delete("x", noKeys)
+	assert.Error(t, err)
+
+	_, err = MySQL.Update("users", &sqlTestStruct{}, FilterInclude([]string{"nothing"}))
+	assert.Error(t, err)
+
+	_, err = SQLServer.Upsert("x", noKeys, nil)
+	assert.Error(t, err)
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "`a``b`", MySQL.Quote("a`b"))
+	assert.Equal(t, "[a]]b]", SQLServer.Quote("a]b"))
+}
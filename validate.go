@@ -0,0 +1,294 @@
+package ncservice
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateMode decides which rules apply as updates can leave out fields that
+// are required on create
+type ValidateMode int
+
+const (
+	ValidateCreate ValidateMode = iota
+	ValidateUpdate
+)
+
+// FieldErr is a problem with a single field of a request
+type FieldErr struct {
+	Field string `json:"field"`
+	Msg   string `json:"message"`
+}
+
+// ValidationErr is an ErrUser that lists every field that failed validation
+type ValidationErr struct {
+	Err
+	Fields []FieldErr `json:"fields"`
+}
+
+// Validate checks a struct against the constraints in its tags and returns a
+// ValidationErr listing every failing JSON field. Supported tags:
+//
+//	binding:"required,oneof='a' 'b'"  required on create, must be one of listed values
+//	binding:"omitempty"                zero values are not checked further
+//	enum:"a,b"                         string must be one of listed values
+//	length:"3..5|10"                   string length
+//	range:"10..500"                    number range, min and max are allowed
+//	pattern:"[A-Z]+"                   regular expressions, newline separated
+//
+// Several length or range restrictions are separated by commas and must all
+// pass. Elements of slices are checked individually. Patterns are not anchored
+// which is how the YANG parser checks them.
+func Validate(h any, mode ValidateMode) error {
+	ref := reflect.ValueOf(h)
+	if ref.Kind() == reflect.Ptr {
+		ref = ref.Elem()
+	}
+	s := schemaOf(ref.Type())
+	var failed []FieldErr
+	for _, f := range s.fields {
+		if !f.hasJson {
+			continue
+		}
+		if f.rules.err != nil {
+			return fmt.Errorf("invalid validation tags on %s. %w", f.Name, f.rules.err)
+		}
+		v, valid := fieldByIndex(ref, f.index)
+		for _, msg := range f.rules.check(v, valid, mode) {
+			failed = append(failed, FieldErr{Field: f.json, Msg: msg})
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, fe := range failed {
+		msgs[i] = fe.Field + " " + fe.Msg
+	}
+	return ValidationErr{
+		Err:    ErrUserf("invalid fields: %s", strings.Join(msgs, "; ")),
+		Fields: failed,
+	}
+}
+
+// fieldRules are the validation constraints of a field parsed from its tags
+type fieldRules struct {
+	required  bool
+	omitempty bool
+	oneof     []string
+	enum      []string
+	lengths   [][]bounds
+	ranges    [][]bounds
+	patterns  []*regexp.Regexp
+	err       error
+}
+
+// bounds is a single range like 3..5, entries are inclusive
+type bounds struct {
+	min float64
+	max float64
+}
+
+func parseRules(fld reflect.StructField, enum []string) fieldRules {
+	r := fieldRules{enum: enum}
+	for _, part := range strings.Split(fld.Tag.Get("binding"), ",") {
+		switch {
+		case part == "required":
+			r.required = true
+		case part == "omitempty":
+			r.omitempty = true
+		case strings.HasPrefix(part, "oneof="):
+			r.oneof = parseOneOf(strings.TrimPrefix(part, "oneof="))
+		}
+	}
+	if r.lengths, r.err = parseBounds(fld.Tag.Get("length")); r.err != nil {
+		return r
+	}
+	if r.ranges, r.err = parseBounds(fld.Tag.Get("range")); r.err != nil {
+		return r
+	}
+	if patterns := fld.Tag.Get("pattern"); patterns != "" {
+		for _, p := range strings.Split(patterns, "\n") {
+			regx, err := regexp.Compile(p)
+			if err != nil {
+				r.err = err
+				return r
+			}
+			r.patterns = append(r.patterns, regx)
+		}
+	}
+	return r
+}
+
+// parseOneOf reads values like gin's validator where values are separated by
+// spaces and can be single quoted to include spaces
+func parseOneOf(s string) []string {
+	var vals []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '\'' {
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				vals = append(vals, s[1:])
+				break
+			}
+			vals = append(vals, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			vals = append(vals, s)
+			break
+		}
+		vals = append(vals, s[:end])
+		s = s[end:]
+	}
+	return vals
+}
+
+// parseBounds reads YANG style ranges like "1..5|10, min..100"
+func parseBounds(s string) ([][]bounds, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var all [][]bounds
+	for _, restriction := range strings.Split(s, ",") {
+		var entries []bounds
+		for _, entry := range strings.Split(restriction, "|") {
+			entry = strings.TrimSpace(entry)
+			lo, hi, isRange := strings.Cut(entry, "..")
+			if !isRange {
+				hi = lo
+			}
+			var b bounds
+			var err error
+			if b.min, err = parseBound(lo, math.Inf(-1)); err != nil {
+				return nil, err
+			}
+			if b.max, err = parseBound(hi, math.Inf(1)); err != nil {
+				return nil, err
+			}
+			entries = append(entries, b)
+		}
+		all = append(all, entries)
+	}
+	return all, nil
+}
+
+func parseBound(s string, unbounded float64) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "min" || s == "max" {
+		return unbounded, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func withinBounds(n float64, restrictions [][]bounds) bool {
+	for _, entries := range restrictions {
+		if !slices.ContainsFunc(entries, func(b bounds) bool { return n >= b.min && n <= b.max }) {
+			return false
+		}
+	}
+	return true
+}
+
+func describeBounds(restrictions [][]bounds) string {
+	var all []string
+	for _, entries := range restrictions {
+		var s []string
+		for _, b := range entries {
+			if b.min == b.max {
+				s = append(s, fmt.Sprint(b.min))
+				continue
+			}
+			lo, hi := "min", "max"
+			if !math.IsInf(b.min, -1) {
+				lo = fmt.Sprint(b.min)
+			}
+			if !math.IsInf(b.max, 1) {
+				hi = fmt.Sprint(b.max)
+			}
+			s = append(s, lo+".."+hi)
+		}
+		all = append(all, strings.Join(s, "|"))
+	}
+	return strings.Join(all, ", ")
+}
+
+func (r fieldRules) empty() bool {
+	return !r.required && r.oneof == nil && r.enum == nil && r.lengths == nil &&
+		r.ranges == nil && r.patterns == nil
+}
+
+// check returns a message for each rule the value breaks
+func (r fieldRules) check(v reflect.Value, valid bool, mode ValidateMode) []string {
+	if r.empty() {
+		return nil
+	}
+	// like gin, a pointer that is set is present even when it points to a zero value
+	absent := !valid || v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0)
+	if absent {
+		if r.required && mode == ValidateCreate {
+			return []string{"is required"}
+		}
+		if !valid || v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice || r.omitempty || mode == ValidateUpdate {
+			return nil
+		}
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice && !isBytes(v.Type()) {
+		var msgs []string
+		for i := range v.Len() {
+			for _, msg := range r.checkValue(v.Index(i)) {
+				msgs = append(msgs, fmt.Sprintf("item %d %s", i, msg))
+			}
+		}
+		return msgs
+	}
+	return r.checkValue(v)
+}
+
+func (r fieldRules) checkValue(v reflect.Value) []string {
+	var msgs []string
+	s := fmt.Sprint(v.Interface())
+	if r.oneof != nil {
+		if !slices.Contains(r.oneof, s) {
+			msgs = append(msgs, "must be one of "+strings.Join(r.oneof, ", "))
+		}
+	} else if r.enum != nil && v.Kind() == reflect.String && !slices.Contains(r.enum, s) {
+		msgs = append(msgs, "must be one of "+strings.Join(r.enum, ", "))
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		if r.lengths != nil && !withinBounds(float64(utf8.RuneCountInString(s)), r.lengths) {
+			msgs = append(msgs, "length must be "+describeBounds(r.lengths))
+		}
+		for _, p := range r.patterns {
+			if !p.MatchString(s) {
+				msgs = append(msgs, "must match "+p.String())
+			}
+		}
+	case isInt(v.Type()) || isUint(v.Type()) || isFloat(v.Type()):
+		var n float64
+		switch {
+		case isInt(v.Type()):
+			n = float64(v.Int())
+		case isUint(v.Type()):
+			n = float64(v.Uint())
+		default:
+			n = v.Float()
+		}
+		if r.ranges != nil && !withinBounds(n, r.ranges) {
+			msgs = append(msgs, "must be in range "+describeBounds(r.ranges))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,80 @@
+package ncservice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validateTestStruct struct {
+	Name    string   `json:"name" binding:"required" length:"3..5"`
+	Status  string   `json:"status" binding:"required,oneof='active' 'on hold'" enum:"active,onHold"`
+	Kind    string   `json:"kind,omitempty" binding:"omitempty" enum:"a,b"`
+	Level   int      `json:"level" binding:"omitempty,oneof=1 2 3"`
+	Count   *int     `json:"count" binding:"omitempty" range:"10..500|1000"`
+	Code    *string  `json:"code" binding:"omitempty" pattern:"^[A-Z]+$\n^.{2}"`
+	Emails  []string `json:"emails" binding:"omitempty" length:"min..10"`
+	Ignored string   `json:"-" binding:"required"`
+}
+
+func TestValidate(t *testing.T) {
+	valid := validateTestStruct{
+		Name:   "joe",
+		Status: "on hold",
+		Count:  Ptr(1000),
+		Code:   Ptr("AB"),
+		Emails: []string{"a@b.c"},
+	}
+	assert.NoError(t, Validate(&valid, ValidateCreate))
+	assert.NoError(t, Validate(validateTestStruct{}, ValidateUpdate))
+
+	err := Validate(validateTestStruct{}, ValidateCreate)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUser))
+	var verr ValidationErr
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, []FieldErr{
+		{Field: "name", Msg: "is required"},
+		{Field: "status", Msg: "is required"},
+	}, verr.Fields)
+	assert.Equal(t, "invalid fields: name is required; status is required", err.Error())
+
+	invalid := validateTestStruct{
+		Name:   "joseph",
+		Status: "gone",
+		Kind:   "c",
+		Level:  4,
+		Count:  Ptr(501),
+		Code:   Ptr("A1"),
+		Emails: []string{"short", "way.too.long@example.com"},
+	}
+	for _, mode := range []ValidateMode{ValidateCreate, ValidateUpdate} {
+		err = Validate(&invalid, mode)
+		require.True(t, errors.As(err, &verr))
+		assert.Equal(t, []FieldErr{
+			{Field: "name", Msg: "length must be 3..5"},
+			{Field: "status", Msg: "must be one of active, on hold"},
+			{Field: "kind", Msg: "must be one of a, b"},
+			{Field: "level", Msg: "must be one of 1, 2, 3"},
+			{Field: "count", Msg: "must be in range 10..500|1000"},
+			{Field: "code", Msg: "must match ^[A-Z]+$"},
+			{Field: "emails", Msg: "item 1 length must be min..10"},
+		}, verr.Fields)
+	}
+}
+
+func TestValidateBadTags(t *testing.T) {
+	x := struct {
+		N int `json:"n" range:"1..x"`
+	}{}
+	err := Validate(x, ValidateCreate)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUser))
+}
+
+func TestParseOneOf(t *testing.T) {
+	assert.Equal(t, []string{"a", "b c", "d"}, parseOneOf("a 'b c' d"))
+	assert.Equal(t, []string{"1", "2"}, parseOneOf("1 2"))
+}
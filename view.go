@@ -0,0 +1,251 @@
+package ncservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// VIEW_FULL requests the fields tagged `show:"full"` in addition to the summary
+const VIEW_FULL = "full"
+
+// View is the projection of a struct returned to a client. The summary view,
+// the zero value, leaves out fields tagged `show:"full"` and fields in a
+// `group:"a,b"` tag. Full adds the show:"full" fields and Groups add the fields in
+// those groups. GROUP_ALL as a group includes everything. Primary keys are
+// always included.
+type View struct {
+	Full   bool
+	Groups []string
+}
+
+// ParseView reads a comma separated list like "full,billing" that is typically
+// a query parameter. An empty string is the summary view.
+func ParseView(s string) View {
+	var v View
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+		case VIEW_FULL:
+			v.Full = true
+		default:
+			v.Groups = append(v.Groups, name)
+		}
+	}
+	return v
+}
+
+func (v View) all() bool {
+	return slices.Contains(v.Groups, GROUP_ALL)
+}
+
+// Includes tells if a field is part of the view. Marshal and Encode also leave
+// out the fields of nested structs that are not part of the view.
+func (v View) Includes(fld reflect.StructField) bool {
+	if v.all() {
+		return true
+	}
+	if _, isKey := getGormTag(fld.Tag.Get("gorm"), "primaryKey"); isKey {
+		return true
+	}
+	if fld.Tag.Get("show") == VIEW_FULL && !v.Full {
+		return false
+	}
+	if groups := fld.Tag.Get("group"); groups != "" {
+		for _, g := range strings.Split(groups, ",") {
+			if slices.Contains(v.Groups, strings.TrimSpace(g)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// includes is Includes for a field and every nested struct holding it
+func (v View) includes(sf structField) bool {
+	for _, parent := range sf.parents {
+		if !v.Includes(parent) {
+			return false
+		}
+	}
+	return v.Includes(sf.StructField)
+}
+
+// Filter passes the values of fields in the view so the same projection can be
+// used to select columns
+func (v View) Filter() ValueFilter {
+	return func(_ Value, fld reflect.StructField) bool {
+		return v.Includes(fld)
+	}
+}
+
+// Marshal encodes a struct, or a slice of structs, as JSON with only the fields
+// in the view. Field names, nesting and omitempty follow the json tags just as
+// encoding/json does.
+func (v View) Marshal(h any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := v.Encode(&buf, h); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the JSON of Marshal to w
+func (v View) Encode(w io.Writer, h any) error {
+	ref := reflect.Indirect(reflect.ValueOf(h))
+	var buf bytes.Buffer
+	switch ref.Kind() {
+	case reflect.Struct:
+		if err := v.encodeStruct(&buf, h); err != nil {
+			return err
+		}
+	case reflect.Slice, reflect.Array:
+		if ref.Kind() == reflect.Slice && ref.IsNil() {
+			buf.WriteString("null")
+			break
+		}
+		buf.WriteByte('[')
+		for i := range ref.Len() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := v.encodeStruct(&buf, ref.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return errors.New("view can only encode a struct or a slice of structs")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// viewNode is a member of a JSON object being encoded, either a value or an
+// object made from a nested struct
+type viewNode struct {
+	name    string
+	val     any
+	members []*viewNode
+}
+
+func (n *viewNode) member(name string) *viewNode {
+	for _, m := range n.members {
+		if m.name == name {
+			return m
+		}
+	}
+	m := &viewNode{name: name}
+	n.members = append(n.members, m)
+	return m
+}
+
+func (v View) encodeStruct(buf *bytes.Buffer, h any) error {
+	if ref := reflect.ValueOf(h); ref.Kind() == reflect.Ptr && ref.IsNil() {
+		buf.WriteString("null")
+		return nil
+	}
+	vals, fields, err := readFieldValues(h, ReadValueOptions{
+		ColumnMapper: ApiColumns,
+		Prefixer:     ApiPrefix,
+	})
+	if err != nil {
+		return err
+	}
+	ref := reflect.Indirect(reflect.ValueOf(h))
+	root := &viewNode{}
+	for i, val := range vals {
+		f := fields[i].structField
+		if !v.includes(f) {
+			continue
+		}
+		path := val.Col
+		if k := nilParent(ref, f); k >= 0 {
+			// a nil nested struct is null, or left out like encoding/json
+			// does when it is embedded without a name or omitempty
+			held := structField{parents: f.parents[:k+1]}
+			prefix := held.prefix(ApiPrefix)
+			if prefix == "" || omitEmpty(f.parents[k]) {
+				continue
+			}
+			path = strings.TrimSuffix(prefix, ".")
+		} else if omitEmpty(f.StructField) && isEmptyJson(val.Val) {
+			continue
+		}
+		n := root
+		for _, seg := range strings.Split(path, ".") {
+			n = n.member(seg)
+		}
+		n.val = val.Val
+	}
+	if root.members == nil {
+		buf.WriteString("{}")
+		return nil
+	}
+	return root.encode(buf)
+}
+
+// nilParent is the index of the first parent of a field that is a nil struct
+// pointer, -1 when there is none
+func nilParent(ref reflect.Value, sf structField) int {
+	for k := range sf.parents {
+		fv, valid := fieldByIndex(ref, sf.index[:k+1])
+		if valid && fv.Kind() == reflect.Ptr && fv.IsNil() {
+			return k
+		}
+	}
+	return -1
+}
+
+func (n *viewNode) encode(buf *bytes.Buffer) error {
+	if n.members == nil {
+		data, err := json.Marshal(n.val)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	}
+	buf.WriteByte('{')
+	for i, m := range n.members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(m.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := m.encode(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func omitEmpty(fld reflect.StructField) bool {
+	opts := strings.Split(fld.Tag.Get("json"), ",")
+	return slices.Contains(opts[1:], "omitempty")
+}
+
+// isEmptyJson matches the values encoding/json leaves out for omitempty
+func isEmptyJson(v any) bool {
+	if v == nil {
+		return true
+	}
+	ref := reflect.ValueOf(v)
+	switch ref.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return ref.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Ptr:
+		return ref.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package ncservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type viewTestAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip" show:"full"`
+}
+
+type viewTestStruct struct {
+	Id      int             `json:"id" gorm:"column:id;primaryKey" show:"full"`
+	Name    string          `json:"name" gorm:"column:name"`
+	Notes   string          `json:"notes,omitempty" gorm:"column:notes" show:"full"`
+	Balance float64         `json:"balance" gorm:"column:balance" group:"billing,admin"`
+	Address viewTestAddress `json:"address" gorm:"embedded"`
+}
+
+func TestParseView(t *testing.T) {
+	assert.Equal(t, View{}, ParseView(""))
+	assert.Equal(t, View{Full: true, Groups: []string{"billing"}}, ParseView("full, billing"))
+	assert.Equal(t, View{Groups: []string{GROUP_ALL}}, ParseView("all"))
+}
+
+func TestViewFilter(t *testing.T) {
+	x := viewTestStruct{Id: 1, Name: "joe", Address: viewTestAddress{City: "x", Zip: "1"}}
+	cols := func(v View) []string {
+		vals, err := Values(&x, v.Filter())
+		require.NoError(t, err)
+		var cols []string
+		for _, v := range vals {
+			cols = append(cols, v.Col)
+		}
+		return cols
+	}
+	assert.Equal(t, []string{"id", "name"}, cols(View{}))
+	assert.Equal(t, []string{"id", "name", "notes"}, cols(View{Full: true}))
+	assert.Equal(t, []string{"id", "name", "balance"}, cols(ParseView("admin")))
+	assert.Equal(t, []string{"id", "name", "notes", "balance"}, cols(ParseView(GROUP_ALL)))
+}
+
+func TestViewMarshal(t *testing.T) {
+	x := &viewTestStruct{Id: 1, Name: "joe", Balance: 2.5, Address: viewTestAddress{City: "x", Zip: "1"}}
+	data, err := View{}.Marshal(x)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1,"name":"joe","address":{"city":"x"}}`, string(data))
+
+	data, err = ParseView("full,billing").Marshal(x)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1,"name":"joe","balance":2.5,"address":{"city":"x","zip":"1"}}`, string(data))
+
+	x.Notes = "vip"
+	var buf bytes.Buffer
+	require.NoError(t, ParseView("full").Encode(&buf, []viewTestStruct{*x, {Id: 2}}))
+	assert.Equal(t, `[{"id":1,"name":"joe","notes":"vip","address":{"city":"x","zip":"1"}},`+
+		`{"id":2,"name":"","address":{"city":"","zip":""}}]`, buf.String())
+
+	data, err = View{}.Marshal(struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+
+	_, err = View{}.Marshal(3)
+	assert.Error(t, err)
+}
+
+func TestViewNested(t *testing.T) {
+	type card struct {
+		Number string `json:"number"`
+	}
+	type base struct {
+		Created string `json:"created"`
+	}
+	type nested struct {
+		*base
+		Id      int              `json:"id" gorm:"column:id;primaryKey"`
+		Card    card             `json:"card" gorm:"embedded" group:"billing"`
+		Ship    *viewTestAddress `json:"ship" gorm:"embedded"`
+		Bill    *viewTestAddress `json:"bill,omitempty" gorm:"embedded"`
+		Private viewTestAddress  `json:"private" gorm:"embedded" show:"full"`
+	}
+	x := &nested{Id: 1, Card: card{Number: "4111"}, Private: viewTestAddress{City: "x"}}
+	data, err := View{}.Marshal(x)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1,"ship":null}`, string(data))
+
+	x.base = &base{Created: "today"}
+	x.Ship = &viewTestAddress{City: "y"}
+	data, err = ParseView("full,billing").Marshal(x)
+	require.NoError(t, err)
+	assert.Equal(t, `{"created":"today","id":1,"card":{"number":"4111"},"ship":{"city":"y","zip":""},`+
+		`"private":{"city":"x","zip":""}}`, string(data))
+	expected, err := json.Marshal(x)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(data))
+}
@@ -0,0 +1,380 @@
+package ncservice
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Where is a parsed filter expression for the rows of a list. The grammar is
+//
+//	expr    = term { "or" term }
+//	term    = factor { "and" factor }
+//	factor  = "not" factor | "(" expr ")" | field "pr" | field op value
+//	op      = "eq" | "ne" | "gt" | "ge" | "lt" | "le" | "co" | "sw" | "ew"
+//	value   = 'text' | number | "true" | "false" | "null"
+//
+// like
+//
+//	status eq 'active' and (number gt 100 or lastName eq 'O''Brien')
+//
+// Keywords are case insensitive, a quote inside text is written as two quotes
+// as above and fields are JSON names including dotted paths into nested
+// structs. co, sw and ew are contains, starts with and ends with for text and pr
+// is present, not null.
+type Where struct {
+	root whereNode
+	// joined is true when T has fields of other tables so columns of the
+	// main table have to be qualified too
+	joined bool
+}
+
+// ParseWhere parses a filter expression on the JSON fields of T. Fields have to
+// be searchable, they need a column, cannot be passwords and cannot be tagged
+// `search:"no"`. Errors are user errors that point at the bad token.
+func ParseWhere[T any](expr string) (*Where, error) {
+	s := schemaOf(reflect.TypeFor[T]())
+	p := &whereParser{schema: s}
+	if err := p.tokenize(expr); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 1 {
+		return nil, ErrUserf("empty filter")
+	}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEnd {
+		return nil, p.unexpected(t)
+	}
+	joined := slices.ContainsFunc(s.fields, func(f *fieldSchema) bool { return f.table != "" })
+	return &Where{root: root, joined: joined}, nil
+}
+
+// SQL builds the condition without a leading WHERE so it can be combined with
+// other conditions. SQL Server placeholders start at @p1. table is the main
+// table of T, its columns are qualified with it when T has fields of other
+// tables that are joined in the query.
+func (w *Where) SQL(d Dialect, table string) Statement {
+	b := &sqlBuilder{d: d}
+	if !w.joined {
+		table = ""
+	}
+	w.root.write(b, table)
+	return b.statement()
+}
+
+// whereNode writes a condition, main qualifies the columns of the main table
+// when not empty
+type whereNode interface {
+	write(b *sqlBuilder, main string)
+}
+
+type whereLogic struct {
+	op    string
+	nodes []whereNode
+}
+
+func (n whereLogic) write(b *sqlBuilder, main string) {
+	for i, child := range n.nodes {
+		if i > 0 {
+			b.write(" ", n.op, " ")
+		}
+		// AND binds tighter than OR so only OR inside AND needs parentheses
+		if l, isLogic := child.(whereLogic); isLogic && l.op != n.op && l.op == "OR" {
+			b.write("(")
+			child.write(b, main)
+			b.write(")")
+			continue
+		}
+		child.write(b, main)
+	}
+}
+
+type whereNot struct {
+	node whereNode
+}
+
+func (n whereNot) write(b *sqlBuilder, main string) {
+	b.write("NOT (")
+	n.node.write(b, main)
+	b.write(")")
+}
+
+type whereCompare struct {
+	f   *fieldSchema
+	op  string
+	val any
+}
+
+var whereOps = map[string]string{
+	"eq": "=",
+	"ne": "<>",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+func (n whereCompare) write(b *sqlBuilder, main string) {
+	col := n.f.column
+	if n.f.table != "" {
+		col = n.f.table + "." + col
+	} else if main != "" {
+		col = main + "." + col
+	}
+	b.write(b.d.Quote(col))
+	switch n.op {
+	case "pr":
+		b.write(" IS NOT NULL")
+	case "co", "sw", "ew":
+		pattern := escapeLike(b.d, n.val.(string))
+		if n.op != "sw" {
+			pattern = "%" + pattern
+		}
+		if n.op != "ew" {
+			pattern += "%"
+		}
+		b.write(" LIKE ", b.arg(pattern), " ESCAPE '!'")
+	default:
+		if n.val == nil {
+			if n.op == "eq" {
+				b.write(" IS NULL")
+			} else {
+				b.write(" IS NOT NULL")
+			}
+			return
+		}
+		b.write(" ", whereOps[n.op], " ", b.arg(n.val))
+	}
+}
+
+// escapeLike escapes the wildcards of LIKE using ! which works the same in both
+// dialects, unlike backslash
+func escapeLike(d Dialect, s string) string {
+	special := "!%_"
+	if d == SQLServer {
+		special += "["
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			sb.WriteRune('!')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+type tokKind int
+
+const (
+	tokEnd tokKind = iota
+	tokWord
+	tokText
+	tokNumber
+	tokOpen
+	tokClose
+)
+
+type whereToken struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type whereParser struct {
+	schema *schema
+	tokens []whereToken
+	next   int
+}
+
+func (p *whereParser) tokenize(expr string) error {
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			p.tokens = append(p.tokens, whereToken{kind: tokOpen, text: "(", pos: start})
+			i++
+		case r == ')':
+			p.tokens = append(p.tokens, whereToken{kind: tokClose, text: ")", pos: start})
+			i++
+		case r == '\'':
+			var sb strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return ErrUserf("unterminated text at position %d", start)
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+			}
+			p.tokens = append(p.tokens, whereToken{kind: tokText, text: sb.String(), pos: start})
+		case r == '-' || unicode.IsDigit(r):
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || strings.ContainsRune(".eE+-", runes[i])); i++ {
+			}
+			p.tokens = append(p.tokens, whereToken{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.'); i++ {
+			}
+			p.tokens = append(p.tokens, whereToken{kind: tokWord, text: string(runes[start:i]), pos: start})
+		default:
+			return ErrUserf("unexpected '%c' at position %d", r, start)
+		}
+	}
+	p.tokens = append(p.tokens, whereToken{kind: tokEnd, pos: len(runes)})
+	return nil
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.next]
+}
+
+func (p *whereParser) take() whereToken {
+	t := p.tokens[p.next]
+	if t.kind != tokEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *whereParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *whereParser) unexpected(t whereToken) error {
+	if t.kind == tokEnd {
+		return ErrUserf("unexpected end of filter at position %d", t.pos)
+	}
+	return ErrUserf("unexpected '%s' at position %d", t.text, t.pos)
+}
+
+func (p *whereParser) expr() (whereNode, error) {
+	return p.logic("or", "OR", p.term)
+}
+
+func (p *whereParser) term() (whereNode, error) {
+	return p.logic("and", "AND", p.factor)
+}
+
+func (p *whereParser) logic(kw string, op string, operand func() (whereNode, error)) (whereNode, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []whereNode{first}
+	for p.keyword(kw) {
+		n, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return whereLogic{op: op, nodes: nodes}, nil
+}
+
+func (p *whereParser) factor() (whereNode, error) {
+	if p.keyword("not") {
+		n, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return whereNot{node: n}, nil
+	}
+	t := p.take()
+	switch t.kind {
+	case tokOpen:
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.take(); closing.kind != tokClose {
+			return nil, p.unexpected(closing)
+		}
+		return n, nil
+	case tokWord:
+		return p.compare(t)
+	}
+	return nil, p.unexpected(t)
+}
+
+func (p *whereParser) compare(fieldTok whereToken) (whereNode, error) {
+	f, err := p.schema.fieldOfJson(fieldTok.text)
+	if err != nil || f.password || f.Tag.Get("search") == "no" {
+		return nil, ErrUserf("field '%s' at position %d cannot be searched", fieldTok.text, fieldTok.pos)
+	}
+	opTok := p.take()
+	op := strings.ToLower(opTok.text)
+	if opTok.kind != tokWord {
+		return nil, p.unexpected(opTok)
+	}
+	if op == "pr" {
+		return whereCompare{f: f, op: op}, nil
+	}
+	if _, valid := whereOps[op]; !valid && op != "co" && op != "sw" && op != "ew" {
+		return nil, ErrUserf("unknown operator '%s' at position %d", opTok.text, opTok.pos)
+	}
+	base := baseType(f.Type)
+	if !whereOpFits(op, base) {
+		return nil, ErrUserf("operator '%s' at position %d cannot be used on %s", opTok.text, opTok.pos, f.json)
+	}
+	valTok := p.take()
+	var val any
+	switch {
+	case valTok.kind == tokText || valTok.kind == tokNumber:
+		val = valTok.text
+	case valTok.kind == tokWord && (strings.EqualFold(valTok.text, "true") || strings.EqualFold(valTok.text, "false")):
+		val = strings.ToLower(valTok.text)
+	case valTok.kind == tokWord && strings.EqualFold(valTok.text, "null"):
+		if op != "eq" && op != "ne" {
+			return nil, ErrUserf("null at position %d can only be compared with eq or ne", valTok.pos)
+		}
+		return whereCompare{f: f, op: op}, nil
+	default:
+		return nil, p.unexpected(valTok)
+	}
+	if op == "co" || op == "sw" || op == "ew" {
+		return whereCompare{f: f, op: op, val: val}, nil
+	}
+	converted, err := DefaultConverters.Convert(val, base)
+	if err != nil {
+		return nil, ErrUserf("invalid value '%s' at position %d for %s", valTok.text, valTok.pos, f.json)
+	}
+	return whereCompare{f: f, op: op, val: converted.Interface()}, nil
+}
+
+// whereOpFits tells if an operator makes sense for the Go type of a field
+func whereOpFits(op string, t reflect.Type) bool {
+	isText := t.Kind() == reflect.String
+	ordered := isText || isInt(t) || isUint(t) || isFloat(t) || t == reflect.TypeFor[time.Time]()
+	switch op {
+	case "co", "sw", "ew":
+		return isText
+	case "gt", "ge", "lt", "le":
+		return ordered
+	}
+	return ordered || t.Kind() == reflect.Bool
+}
@@ -0,0 +1,99 @@
+package ncservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type whereTestStruct struct {
+	ID       int              `json:"id" gorm:"column:id;primaryKey"`
+	Status   string           `json:"status" gorm:"column:status"`
+	Number   *int             `json:"number" gorm:"column:num"`
+	LastName string           `json:"lastName" gorm:"column:last_name"`
+	Active   bool             `json:"active" gorm:"column:active"`
+	Created  time.Time        `json:"created" gorm:"column:created"`
+	Pin      string           `json:"pin" gorm:"column:vm_pin" table:"voicemail"`
+	Secret   string           `json:"secret" gorm:"column:secret" password:"true"`
+	Notes    string           `json:"notes" gorm:"column:notes" search:"no"`
+	Address  patchTestAddress `json:"address" gorm:"embedded;embeddedPrefix:addr_"`
+}
+
+func TestParseWhere(t *testing.T) {
+	tests := []struct {
+		expr      string
+		mysql     string
+		sqlserver string
+		args      []any
+	}{
+		{
+			expr:      "status eq 'active' and (number gt 100 or lastName sw 'Sm')",
+			mysql:     "`users`.`status` = ? AND (`users`.`num` > ? OR `users`.`last_name` LIKE ? ESCAPE '!')",
+			sqlserver: "[users].[status] = @p1 AND ([users].[num] > @p2 OR [users].[last_name] LIKE @p3 ESCAPE '!')",
+			args:      []any{"active", 100, "Sm%"},
+		},
+		{
+			expr:      "NOT active EQ true OR address.City co '50%_off' and pin ew 'x'",
+			mysql:     "NOT (`users`.`active` = ?) OR `users`.`addr_city` LIKE ? ESCAPE '!' AND `voicemail`.`vm_pin` LIKE ? ESCAPE '!'",
+			sqlserver: "NOT ([users].[active] = @p1) OR [users].[addr_city] LIKE @p2 ESCAPE '!' AND [voicemail].[vm_pin] LIKE @p3 ESCAPE '!'",
+			args:      []any{true, "%50!%!_off%", "%x"},
+		},
+		{
+			expr:      "number eq null and lastName ne 'O''Brien' and created ge '2024-01-02' and status pr",
+			mysql:     "`users`.`num` IS NULL AND `users`.`last_name` <> ? AND `users`.`created` >= ? AND `users`.`status` IS NOT NULL",
+			sqlserver: "[users].[num] IS NULL AND [users].[last_name] <> @p1 AND [users].[created] >= @p2 AND [users].[status] IS NOT NULL",
+			args:      []any{"O'Brien", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	for _, test := range tests {
+		w, err := ParseWhere[whereTestStruct](test.expr)
+		require.NoError(t, err, test.expr)
+		stmt := w.SQL(MySQL, "users")
+		assert.Equal(t, test.mysql, stmt.SQL)
+		assert.Equal(t, test.args, stmt.Args)
+		stmt = w.SQL(SQLServer, "users")
+		assert.Equal(t, test.sqlserver, stmt.SQL)
+	}
+
+	// columns are only qualified when other tables are joined
+	w, err := ParseWhere[concurrencyTestStruct]("name eq 'joe'")
+	require.NoError(t, err)
+	assert.Equal(t, "`name` = ?", w.SQL(MySQL, "users").SQL)
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "empty filter"},
+		{"status eq", "unexpected end of filter at position 9"},
+		{"status eq 'a' and", "unexpected end of filter at position 17"},
+		{"(status eq 'a'", "unexpected end of filter at position 14"},
+		{"status eq 'a')", "unexpected ')' at position 13"},
+		{"status = 'a'", "unexpected '=' at position 7"},
+		{"status xx 'a'", "unknown operator 'xx' at position 7"},
+		{"bogus eq 1", "field 'bogus' at position 0 cannot be searched"},
+		{"secret eq 'x'", "field 'secret' at position 0 cannot be searched"},
+		{"notes co 'x'", "field 'notes' at position 0 cannot be searched"},
+		{"number co '1'", "operator 'co' at position 7 cannot be used on number"},
+		{"active gt true", "operator 'gt' at position 7 cannot be used on active"},
+		{"number eq 'abc'", "invalid value 'abc' at position 10 for number"},
+		{"status gt null", "null at position 10 can only be compared with eq or ne"},
+		{"status eq 'abc", "unterminated text at position 10"},
+	}
+	for _, test := range tests {
+		_, err := ParseWhere[whereTestStruct](test.expr)
+		require.Error(t, err, test.expr)
+		assert.True(t, errors.Is(err, ErrUser), test.expr)
+		assert.Equal(t, test.err, err.Error(), test.expr)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "a!%b!_c!!d[e", escapeLike(MySQL, "a%b_c!d[e"))
+	assert.Equal(t, "a!%b!_c!!d![e", escapeLike(SQLServer, "a%b_c!d[e"))
+}